@@ -0,0 +1,223 @@
+// Copyright 2026 The Sensible Code Company Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// For function see description of main() method.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+type (
+	Response struct {
+		Data struct {
+			Schema Schema `json:"__schema"`
+		}
+
+		Errors []struct {
+			Message string
+		}
+	}
+
+	Schema struct {
+		QueryType struct{ Name string }
+		Types     []Type
+	}
+
+	Type struct {
+		Name   string
+		Fields []Field
+	}
+
+	Field struct {
+		Name string
+		Args []struct{ Name string }
+		Type TypeRef
+	}
+
+	// TypeRef refers to a type, possibly wrapped in NON_NULL or LIST types.
+	TypeRef struct {
+		Name   string
+		OfType *TypeRef
+	}
+)
+
+// field returns the named field of the named type, or nil if there is no such field.
+func (s Schema) field(typeName, fieldName string) *Field {
+	for i := range s.Types {
+		if s.Types[i].Name != typeName {
+			continue
+		}
+		for j := range s.Types[i].Fields {
+			if f := &s.Types[i].Fields[j]; f.Name == fieldName {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// datasetField returns the named field of the type returned by the dataset query, or nil.
+func (s Schema) datasetField(fieldName string) *Field {
+	dataset := s.field(s.QueryType.Name, "dataset")
+	if dataset == nil {
+		return nil
+	}
+	t := &dataset.Type
+	for t.Name == "" && t.OfType != nil {
+		t = t.OfType
+	}
+	return s.field(t.Name, fieldName)
+}
+
+// hasArg returns true if f is present and takes the named argument.
+func (f *Field) hasArg(name string) bool {
+	if f != nil {
+		for _, arg := range f.Args {
+			if arg.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// features lists what is reported, and how support is detected from the schema.
+var features = []struct {
+	Name, Description string
+	supported         func(s Schema) bool
+}{
+	{"filters", "Restricting table categories with filters", func(s Schema) bool {
+		return s.datasetField("table").hasArg("filters")
+	}},
+	{"rule variables", "Rule based redaction of categories", func(s Schema) bool {
+		return s.datasetField("ruleBase") != nil
+	}},
+	{"pagination", "Paging through variables", func(s Schema) bool {
+		f := s.datasetField("variables")
+		return f.hasArg("first") || f.hasArg("skip")
+	}},
+	{"meta", "Dataset and variable metadata", func(s Schema) bool {
+		return s.datasetField("meta") != nil
+	}},
+	{"search", "Searching for variables and categories", func(s Schema) bool {
+		return s.datasetField("search") != nil
+	}},
+}
+
+const graphQLQuery = `
+{
+ __schema {
+  queryType { name }
+  types {
+   name
+   fields {
+    name
+    args { name }
+    type { name ofType { name ofType { name } } }
+   }
+  }
+ }
+}`
+
+var (
+	apiUrl = flag.String("u", "http://localhost:8492/graphql",
+		"Extended API URL")
+	jsonOutput = flag.Bool("json", false,
+		"Write the report as JSON")
+)
+
+func init() {
+	const usage = `Usage: %s [options]
+
+Introspects the GraphQL schema of the extended API and reports to stdout
+which optional features the server supports.
+Exit code is one on error and errors are reported to stderr.
+
+Options:
+`
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+// This example demonstrates how GraphQL introspection may be used to discover
+// which features of the extended API a server supports, which helps explain why
+// queries using them fail. See usage above or run program for help.
+func main() {
+	if flag.Parse(); len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	if err := enc.Encode(map[string]interface{}{"query": graphQLQuery}); err != nil {
+		log.Fatalf("Error encoding JSON request body: %s", err)
+	}
+
+	resp, err := http.Post(*apiUrl, "application/json", &b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatal(resp.Status)
+	}
+
+	var gqlResp Response
+	if err = json.NewDecoder(resp.Body).Decode(&gqlResp); err != nil {
+		log.Fatal(err)
+	}
+	if len(gqlResp.Errors) > 0 {
+		log.Fatalf("Unexpected error: %v", gqlResp.Errors)
+	}
+	schema := gqlResp.Data.Schema
+
+	if *jsonOutput {
+		report := make(map[string]bool, len(features))
+		for _, f := range features {
+			report[f.Name] = f.supported(schema)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FEATURE\tSUPPORTED\tDESCRIPTION")
+	for _, f := range features {
+		supported := "no"
+		if f.supported(schema) {
+			supported = "yes"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Name, supported, f.Description)
+	}
+	if err := tw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
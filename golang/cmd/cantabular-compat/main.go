@@ -0,0 +1,135 @@
+// Copyright 2026 The Sensible Code Company Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// For function see description of main() method.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+var (
+	apiUrl = flag.String("u", "http://localhost:8492/graphql",
+		"Extended API URL")
+	parallel = flag.Int("parallel", 4,
+		"Number of queries to make at once")
+)
+
+func init() {
+	const usage = `Usage: %s [options] <dataset-name> <var> <var> [<var> ...]
+
+Tests which pairs of the variables can be tabulated together without the table
+being blocked by disclosure control, and writes the compatibility matrix to stdout
+as CSV: "ok" where the pair may be tabulated and "blocked" where not. Only the
+dimensions of each table are requested, so the queries are cheap. The reasons
+tables are blocked are reported to stderr.
+
+Options:
+`
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+// blockedQuery asks whether a table is blocked without requesting its values.
+const blockedQuery = `
+query($dataset: String!, $variables: [String!]!) {
+ dataset(name: $dataset) {
+  table(variables: $variables) {
+   dimensions { count }
+   error
+  }
+ }
+}`
+
+// This example demonstrates checking which combinations of variables are allowed
+// before making queries. See usage above or run program for help.
+func main() {
+	if flag.Parse(); len(flag.Args()) < 3 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	dataset, vars := flag.Arg(0), flag.Args()[1:]
+	client := cantabular.NewClient(*apiUrl)
+
+	// blocked[i][j] is the reason the table of vars i and j is blocked, or "" if it isn't
+	blocked := make([][]string, len(vars))
+	for i := range blocked {
+		blocked[i] = make([]string, len(vars))
+	}
+	type pair struct{ i, j int }
+	pairs := make(chan pair)
+	var wg sync.WaitGroup
+	for n := 0; n < max(*parallel, 1); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pairs {
+				var data struct {
+					Dataset struct{ Table cantabular.Table }
+				}
+				if err := client.Do(context.Background(), blockedQuery, map[string]interface{}{
+					"dataset":   dataset,
+					"variables": []string{vars[p.i], vars[p.j]},
+				}, &data); err != nil {
+					log.Fatalf("%s and %s: %s", vars[p.i], vars[p.j], err)
+				}
+				blocked[p.i][p.j] = data.Dataset.Table.Error
+				blocked[p.j][p.i] = data.Dataset.Table.Error
+			}
+		}()
+	}
+	for i := range vars {
+		for j := i + 1; j < len(vars); j++ {
+			pairs <- pair{i, j}
+		}
+	}
+	close(pairs)
+	wg.Wait()
+
+	cw := csv.NewWriter(os.Stdout)
+	_ = cw.Write(append([]string{""}, vars...))
+	for i, v := range vars {
+		row := []string{v}
+		for j := range vars {
+			switch {
+			case i == j:
+				row = append(row, "")
+			case blocked[i][j] != "":
+				row = append(row, "blocked")
+				if i < j {
+					log.Printf("%s and %s: %s", v, vars[j], blocked[i][j])
+				}
+			default:
+				row = append(row, "ok")
+			}
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2026 The Sensible Code Company Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// For function see description of main() method.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+var (
+	apiUrl = flag.String("u", "http://localhost:8492/graphql",
+		"Extended API URL")
+	outputDir = flag.String("o", "",
+		"Directory to write the pack to, overriding the output of the definition")
+)
+
+func init() {
+	const usage = `Usage: %s [options] <pack.json>
+
+Produces a publication pack: a consistently formatted set of tables described by
+the pack definition, with a contents index. The definition is JSON of the form
+
+  {
+    "dataset": "<dataset-name>",
+    "output": "<directory>",
+    "file_name": "{{.ID}}-{{.Variables}}",
+    "formats": ["xlsx", "csv"],
+    "tables": [
+      {
+        "id": "T01",
+        "title": "<title>",
+        "variables": ["<var>", ...],
+        "filters": [{"variable": "<var>", "codes": ["<code>", ...]}],
+        "footnotes": ["<footnote>", ...]
+      }
+    ]
+  }
+
+file_name is a Go template given the ID, Title, Dataset and Variables (joined with
+"-") of each table, and defaults to {{.ID}}. Excel files have the title above the
+table and the footnotes below; CSV files contain just the table. The contents are
+written to contents.csv, and to contents.xlsx with links if Excel files are made.
+
+Options:
+`
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+type (
+	// Pack is the definition of a publication pack
+	Pack struct {
+		Dataset  string
+		Output   string
+		FileName string `json:"file_name"`
+		Formats  []string
+		Tables   []PackTable
+	}
+
+	// PackTable is a table of a pack
+	PackTable struct {
+		ID        string
+		Title     string
+		Variables []string
+		Filters   []cantabular.Filter
+		Footnotes []string
+	}
+
+	// contentsEntry records the files made for a table for the contents index
+	contentsEntry struct {
+		table PackTable
+		files []string
+	}
+)
+
+// This example demonstrates producing a set of formatted outputs from a definition,
+// automating a publication workflow. See usage above or run program for help.
+func main() {
+	if flag.Parse(); len(flag.Args()) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	pack := readPack(flag.Arg(0))
+	if *outputDir != "" {
+		pack.Output = *outputDir
+	}
+	if err := os.MkdirAll(pack.Output, 0o755); err != nil {
+		log.Fatal(err)
+	}
+	naming, err := template.New("file_name").Option("missingkey=error").Parse(pack.FileName)
+	if err != nil {
+		log.Fatalf("file_name: %s", err)
+	}
+
+	client := cantabular.NewClient(*apiUrl)
+	var contents []contentsEntry
+	for _, t := range pack.Tables {
+		table, err := client.Query(context.Background(), pack.Dataset, t.Variables, t.Filters)
+		if err != nil {
+			log.Fatalf("%s: %s", t.ID, err)
+		}
+		var sb strings.Builder
+		if err := naming.Execute(&sb, map[string]string{
+			"ID":        t.ID,
+			"Title":     t.Title,
+			"Dataset":   pack.Dataset,
+			"Variables": strings.Join(t.Variables, "-"),
+		}); err != nil {
+			log.Fatalf("%s: file_name: %s", t.ID, err)
+		}
+		entry := contentsEntry{table: t}
+		for _, format := range pack.Formats {
+			name := sb.String() + "." + format
+			path := filepath.Join(pack.Output, name)
+			switch format {
+			case "csv":
+				err = writeCSV(path, table)
+			case "xlsx":
+				err = writeXLSX(path, pack.Dataset, t, table)
+			}
+			if err != nil {
+				log.Fatalf("%s: %s", path, err)
+			}
+			entry.files = append(entry.files, name)
+		}
+		contents = append(contents, entry)
+		log.Printf("%s: %s", t.ID, strings.Join(entry.files, ", "))
+	}
+	if err := writeContents(pack, contents); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readPack reads and checks the pack definition, filling in defaults.
+func readPack(path string) *Pack {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pack := &Pack{Output: ".", FileName: "{{.ID}}", Formats: []string{"xlsx", "csv"}}
+	if err := json.Unmarshal(b, pack); err != nil {
+		log.Fatalf("%s: %s", path, err)
+	}
+	if pack.Dataset == "" || len(pack.Tables) == 0 {
+		log.Fatalf("%s: dataset and tables are required", path)
+	}
+	for _, f := range pack.Formats {
+		if f != "csv" && f != "xlsx" {
+			log.Fatalf("%s: unknown format %q, expected csv or xlsx", path, f)
+		}
+	}
+	ids := make(map[string]bool)
+	for _, t := range pack.Tables {
+		if t.ID == "" || len(t.Variables) == 0 {
+			log.Fatalf("%s: each table requires an id and variables", path)
+		}
+		if ids[t.ID] {
+			log.Fatalf("%s: table id %q is repeated", path, t.ID)
+		}
+		ids[t.ID] = true
+	}
+	return pack
+}
+
+// writeCSV writes the table to path as CSV.
+func writeCSV(path string, table *cantabular.Table) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := table.WriteCSV(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeXLSX writes the table to path as an Excel workbook, with the title above it
+// and the footnotes and source below it.
+func writeXLSX(path, dataset string, t PackTable, table *cantabular.Table) error {
+	f := excelize.NewFile()
+	defer func() { _ = f.Close() }()
+	const sheet = "Sheet1"
+	if err := f.SetSheetName(sheet, t.ID); err != nil {
+		return err
+	}
+	sw, err := f.NewStreamWriter(t.ID)
+	if err != nil {
+		return err
+	}
+	title, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true, Size: 14}})
+	if err != nil {
+		return err
+	}
+	bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	row := 1
+	setRow := func(values ...interface{}) {
+		if err == nil {
+			cell, _ := excelize.CoordinatesToCellName(1, row)
+			err = sw.SetRow(cell, values)
+			row++
+		}
+	}
+	setRow(excelize.Cell{StyleID: title, Value: t.Title})
+	row++
+	var header []interface{}
+	for _, h := range table.Header() {
+		header = append(header, excelize.Cell{StyleID: bold, Value: h})
+	}
+	setRow(header...)
+	table.ForEachRow(func(r *cantabular.Row) {
+		values := make([]interface{}, 0, len(r.Categories)+1)
+		for _, c := range r.Categories {
+			values = append(values, c.Label)
+		}
+		setRow(append(values, r.Count)...)
+	})
+	row++
+	for _, note := range t.Footnotes {
+		setRow(note)
+	}
+	setRow(fmt.Sprintf("Source: %s", dataset))
+	if err != nil {
+		return err
+	}
+	if err := sw.Flush(); err != nil {
+		return err
+	}
+	return f.SaveAs(path)
+}
+
+// writeContents writes the contents index of the pack.
+func writeContents(pack *Pack, contents []contentsEntry) error {
+	f, err := os.Create(filepath.Join(pack.Output, "contents.csv"))
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	_ = cw.Write([]string{"id", "title", "variables", "files"})
+	for _, e := range contents {
+		_ = cw.Write([]string{e.table.ID, e.table.Title, strings.Join(e.table.Variables, " "), strings.Join(e.files, " ")})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	xlsx := false
+	for _, format := range pack.Formats {
+		xlsx = xlsx || format == "xlsx"
+	}
+	if !xlsx {
+		return nil
+	}
+	x := excelize.NewFile()
+	defer func() { _ = x.Close() }()
+	const sheet = "Contents"
+	if err := x.SetSheetName("Sheet1", sheet); err != nil {
+		return err
+	}
+	bold, err := x.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	if err := x.SetSheetRow(sheet, "A1", &[]string{"ID", "Title", "Variables"}); err != nil {
+		return err
+	}
+	if err := x.SetCellStyle(sheet, "A1", "C1", bold); err != nil {
+		return err
+	}
+	for i, e := range contents {
+		row := i + 2
+		cell, _ := excelize.CoordinatesToCellName(1, row)
+		if err := x.SetSheetRow(sheet, cell, &[]string{e.table.ID, e.table.Title, strings.Join(e.table.Variables, ", ")}); err != nil {
+			return err
+		}
+		for _, name := range e.files {
+			if strings.HasSuffix(name, ".xlsx") {
+				if err := x.SetCellHyperLink(sheet, cell, name, "External"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return x.SaveAs(filepath.Join(pack.Output, "contents.xlsx"))
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// benchTimeout bounds each query of benchQuery, so that a stalled connection fails
+// the benchmark rather than hanging it.
+const benchTimeout = time.Minute
+
+// benchQuery benchmarks making the query of the command line with one client shared
+// by 1 to goroutines goroutines, doubling, as a server's handlers would, and reports
+// the time and allocations per query and the queries a second of each to stdout.
+// This shows how far the API and the client's pool of connections scale.
+func benchQuery(client *cantabular.Client, goroutines int) {
+	dataset, vars := flag.Arg(0), flag.Args()[1:]
+	// each query has its own context, as each request to a server would
+	query := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), benchTimeout)
+		defer cancel()
+		_, err := client.Query(ctx, dataset, vars, filters)
+		return err
+	}
+	// query once first as errors in the benchmark goroutines can't be reported
+	if err := query(); err != nil {
+		fatal(exitCode(err), err)
+	}
+	for n := 1; ; n *= 2 {
+		if n > goroutines {
+			n = goroutines
+		}
+		var (
+			once     sync.Once
+			firstErr error
+		)
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			var next int64
+			var wg sync.WaitGroup
+			for g := 0; g < n; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for atomic.AddInt64(&next, 1) <= int64(b.N) {
+						if err := query(); err != nil {
+							once.Do(func() { firstErr = err })
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+		})
+		if firstErr != nil {
+			fatal(exitCode(firstErr), firstErr)
+		}
+		perSec := float64(result.N) / result.T.Seconds()
+		fmt.Printf("goroutines=%-4d %s\t%s\t%.1f queries/s\n", n, result, result.MemString(), perSec)
+		if n == goroutines {
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// The exit codes of the classes of failure, as for cantabular-query-streamed.
+const (
+	exitFailure = 1 // any other error
+	exitEmpty   = 2 // the table has no cells
+	exitUsage   = 3 // the command line is invalid
+	exitNetwork = 4 // the API could not be reached, or did not respond with 200 OK
+	exitGraphQL = 5 // the API reported GraphQL errors
+	exitBlocked = 6 // the table was blocked by disclosure control
+	exitOutput  = 7 // the output could not be written
+)
+
+// fatal logs err and exits with code, as log.Fatal does with 1.
+func fatal(code int, err error) {
+	log.Print(err)
+	os.Exit(code)
+}
+
+// exitCode returns the exit code of an error returned by the client.
+func exitCode(err error) int {
+	var gqlErr *cantabular.GraphQLError
+	var tableErr *cantabular.TableError
+	var statusErr *cantabular.StatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &gqlErr):
+		return exitGraphQL
+	case errors.As(err, &tableErr):
+		return exitBlocked
+	case errors.As(err, &statusErr), errors.As(err, &netErr):
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// parseFlags parses the command line, exiting with exitUsage if it is invalid, as
+// the flag package has reported why, or successfully if -h was given.
+func parseFlags() {
+	if err := flag.CommandLine.Parse(os.Args[1:]); errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil || flag.NArg() < 2 {
+		if err == nil {
+			flag.Usage()
+		}
+		os.Exit(exitUsage)
+	}
+}
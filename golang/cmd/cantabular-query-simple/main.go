@@ -17,7 +17,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/csv"
 	"encoding/json"
 	"flag"
@@ -26,35 +26,19 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cantabular/examples/pkg/cantabular"
 )
 
+// Table is read here with a cantabular.TableReader rather than decoded into a
+// cantabular.Table so that its values can be spilled to a file as they are received,
+// see Values.
 type (
-	Response struct {
-		Data struct {
-			Dataset struct {
-				Table Table
-			}
-		}
-
-		Errors []struct {
-			Message string
-		}
-	}
-
 	Table struct {
-		Dimensions []struct {
-			Count      int
-			Categories []Category
-			Variable   Variable
-		}
-
-		Values []int
-		Error  string
-	}
-
-	Variable struct {
-		Name, Label string
+		Dimensions []cantabular.Dimension
+		Values     Values
 	}
 
 	Category struct {
@@ -63,18 +47,35 @@ type (
 
 	Row struct {
 		Categories []Category
-		Count      int
+		Count      float64
 	}
+
+	// Filters is a flag.Value for filters given as <var>=<code>,<code>...
+	Filters []cantabular.Filter
 )
 
-// ForEachRow calls the provided function for each row of the returned data.
-//
-// Panics if the table contains an error.
-func (t Table) ForEachRow(cb func(row *Row)) {
-	if t.Error != "" {
-		panic(t.Error)
+func (fs *Filters) String() string {
+	if fs == nil {
+		return ""
+	}
+	s := make([]string, 0, len(*fs))
+	for _, f := range *fs {
+		s = append(s, f.Variable+"="+strings.Join(f.Codes, ","))
 	}
+	return strings.Join(s, " ")
+}
+
+func (fs *Filters) Set(s string) error {
+	variable, codes, ok := strings.Cut(s, "=")
+	if !ok || variable == "" || codes == "" {
+		return fmt.Errorf("expected <var>=<code>,<code>... but got %q", s)
+	}
+	*fs = append(*fs, cantabular.Filter{Variable: variable, Codes: strings.Split(codes, ",")})
+	return nil
+}
 
+// ForEachRow calls the provided function for each row of the returned data.
+func (t Table) ForEachRow(cb func(row *Row)) {
 	numDimensions := len(t.Dimensions)
 
 	// first, get a slice containing the length of each dimension:
@@ -89,7 +90,7 @@ func (t Table) ForEachRow(cb func(row *Row)) {
 	// finally, iterate through the rows and update the indices.
 	row := Row{Categories: make([]Category, numDimensions)}
 
-	for i := range t.Values {
+	for i := 0; i < t.Values.Len(); i++ {
 		t.populateRow(&row, dimIndices, i)
 		cb(&row)
 
@@ -111,7 +112,19 @@ func (t Table) populateRow(row *Row, indices []int, i int) {
 		rowCat := &row.Categories[j]
 		rowCat.Code, rowCat.Label = dimCat.Code, dimCat.Label
 	}
-	row.Count = t.Values[i]
+	row.Count = t.Values.At(i)
+}
+
+// EmptyDimensions returns the variable names of the dimensions with no categories, as
+// when a filter removes every category of a variable, so that the table has no cells.
+func (t Table) EmptyDimensions() []string {
+	var names []string
+	for _, d := range t.Dimensions {
+		if d.Count == 0 {
+			names = append(names, d.Variable.Name)
+		}
+	}
+	return names
 }
 
 func (t Table) Header() []string {
@@ -122,38 +135,70 @@ func (t Table) Header() []string {
 	return append(result, "count")
 }
 
-const graphQLQuery = `
-query($dataset: String!, $variables: [String!]!, $filters: [Filter!]) {
- dataset(name: $dataset) {
-  table(variables: $variables, filters: $filters) {
-   dimensions {
-    count
-    variable {
-     name
-     label
-    }
-    categories {
-     code
-     label
-    }
-   }
-   values
-   error
-  }
- }
-}`
-
 var apiUrl = flag.String("u", "http://localhost:8492/graphql",
 	"Extended API URL")
 
+var (
+	authToken = flag.String("auth-token", "",
+		"Token to authenticate with as an Authorization: Bearer header (default $"+cantabular.TokenEnv+")")
+	apiKeyHeader = flag.String("api-key-header", "",
+		"Also send the -auth-token in this header, e.g. X-API-Key, for proxies expecting an API key")
+	caCert = flag.String("cacert", "",
+		"Verify the API with the CA certificates in this PEM file rather than the system's")
+	clientCert = flag.String("cert", "",
+		"Authenticate with the client certificate in this PEM file, for mutual TLS, with -key")
+	clientKey = flag.String("key", "",
+		"Private key of the -cert client certificate, in a PEM file")
+	insecure = flag.Bool("insecure", false,
+		"Skip verification of the TLS certificate of the API, e.g. for a test server")
+	proxy = flag.String("proxy", "",
+		"Connect to the API through this http, https or socks5 proxy URL (default $HTTPS_PROXY or\n"+
+			"$HTTP_PROXY, except for hosts in $NO_PROXY)")
+	retries = flag.Int("retries", 0,
+		"Retry requests failing with a connection error or a -retry-on status up to this many times")
+	retryBackoff = flag.Duration("retry-backoff", time.Second,
+		"Delay before the first retry, doubled with jitter for each further retry")
+	retryOn = flag.String("retry-on", "502,503",
+		"Comma-separated HTTP statuses which are retried")
+	decimals = flag.Int("decimals", -1,
+		"Write values with this many decimal places, for weighted datasets whose values may be\n"+
+			"fractional (-1 for as few as represent each value exactly)")
+	ruleReport = flag.String("rule-report", "",
+		"Write a JSON report of the disclosure control status of the table to this file, even if it is blocked")
+	benchGoroutines = flag.Int("bench-concurrency", 0,
+		"Rather than write the table, benchmark querying it from 1 up to this many goroutines sharing\n"+
+			"one client, doubling, and report the time per query and queries a second of each")
+)
+
+var (
+	filters Filters
+	headers cantabular.Headers
+)
+
 func init() {
+	flag.Var(&filters, "f",
+		"Restrict a variable to categories, as <var>=<code>,<code>... May be repeated")
+	flag.Var(&headers, "H",
+		"Send a header with the request, as \"<name>: <value>\", e.g. for an API gateway. May be repeated")
+	flag.IntVar(&SpillThreshold, "spill-threshold", 10000000,
+		"Store the table values in a temporary file when there are more than this many (0 for never)")
+
 	const usage = `Usage: %s <dataset-name> <var> [<var> ...]
 
 Writes table output to stdout as CSV.
-Exit code is one on error and errors are reported to stderr.
+Errors are reported to stderr. The exit code is:
+  1  on any other error
+  2  if a filter removed every category of a variable, so that the table has no cells
+  3  if the command line is invalid
+  4  if the API could not be reached or did not respond with 200 OK
+  5  if the API reported GraphQL errors
+  6  if the table was blocked by disclosure control
+  7  if the output could not be written
 
 Options:
 `
+	// parseFlags, rather than the flag package, exits on invalid flags, see exitUsage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
@@ -164,50 +209,62 @@ Options:
 // may be processed in the simplest way possible using decoding into a Go type.
 // See usage above or run program for help.
 func main() {
-	if flag.Parse(); len(flag.Args()) < 2 {
-		flag.Usage()
-		os.Exit(1)
-	}
+	parseFlags()
 
-	var b bytes.Buffer
-	enc := json.NewEncoder(&b)
-	if err := enc.Encode(map[string]interface{}{
-		"query": graphQLQuery,
-		"variables": map[string]interface{}{
-			"dataset":   flag.Arg(0),
-			"variables": flag.Args()[1:],
-		},
-	}); err != nil {
-		log.Fatalf("Error encoding JSON request body: %s", err)
+	retryStatuses, err := cantabular.ParseStatuses(*retryOn)
+	if err != nil {
+		fatal(exitUsage, fmt.Errorf("-retry-on: %s", err))
 	}
-
-	resp, err := http.Post(*apiUrl, "application/json", &b)
+	if *authToken == "" {
+		*authToken = os.Getenv(cantabular.TokenEnv)
+	}
+	base, err := cantabular.TransportOptions{
+		TLS:   cantabular.TLSOptions{CAFile: *caCert, CertFile: *clientCert, KeyFile: *clientKey, Insecure: *insecure},
+		Proxy: *proxy,
+		// the goroutines of -bench-concurrency share the client's connections
+		IdleConns: *benchGoroutines,
+	}.Transport()
 	if err != nil {
-		log.Fatal(err)
+		fatal(exitUsage, err)
 	}
-
-	// Decode the response.
-	var gqlResp Response
-	if err = json.NewDecoder(resp.Body).Decode(&gqlResp); err != nil {
-		log.Fatal(err)
+	client := cantabular.NewClient(*apiUrl, cantabular.WithAuthToken(*authToken, *apiKeyHeader), cantabular.WithHeaders(http.Header(headers)), cantabular.WithHTTPClient(&http.Client{
+		Transport: &cantabular.RetryTransport{Base: base, Policy: cantabular.RetryPolicy{
+			MaxAttempts: *retries + 1,
+			Backoff:     *retryBackoff,
+			RetryOn:     retryStatuses,
+			OnRetry: func(attempt int, delay time.Duration, reason string) {
+				log.Printf("%s, making attempt %d in %s", reason, attempt, delay.Round(time.Millisecond))
+			},
+		}},
+	}))
+	if *benchGoroutines > 0 {
+		benchQuery(client, *benchGoroutines)
+		return
 	}
-	defer func() { _ = resp.Body.Close() }()
-
-	// Check for table errors
-	if len(gqlResp.Errors) > 0 {
-		log.Fatalf("Unexpected error: %v", gqlResp.Errors)
+	if *ruleReport != "" {
+		writeRuleReport(client, *ruleReport)
+	}
+	table := readTable(client)
+	code := 0
+	if empty := table.EmptyDimensions(); len(empty) > 0 {
+		log.Printf("the table has no cells as no categories of %s remain after filtering",
+			strings.Join(empty, ", "))
+		// the header is still written, before exiting with the code
+		code = exitEmpty
+	}
+	if err := writeCSV(table); err != nil {
+		fatal(exitOutput, err)
 	}
-	table := gqlResp.Data.Dataset.Table
+	os.Exit(code)
+}
+
+// writeCSV writes the table to stdout as CSV, releasing its values once written.
+func writeCSV(table *Table) error {
+	defer func() { _ = table.Values.Close() }()
 
 	// Iterate through each row, and print it:
 	cw := csv.NewWriter(os.Stdout)
-	defer func() {
-		cw.Flush()
-		if err := cw.Error(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	// csv.Writer errors are sticky: log in defer
+	// csv.Writer errors are sticky: checked once flushed
 	_ = cw.Write(table.Header())
 
 	var columns []string
@@ -216,6 +273,50 @@ func main() {
 		for i := range row.Categories {
 			columns = append(columns, row.Categories[i].Label)
 		}
-		_ = cw.Write(append(columns, strconv.Itoa(row.Count)))
+		_ = cw.Write(append(columns, cantabular.FormatValue(row.Count, *decimals)))
 	})
+	cw.Flush()
+	return cw.Error()
+}
+
+// readTable queries the table, reading its values as they are received so that
+// they are spilled to a file rather than held in memory if there are too many.
+func readTable(client *cantabular.Client) *Table {
+	tr, err := client.OpenTable(context.Background(), flag.Arg(0), flag.Args()[1:], filters)
+	if err != nil {
+		fatal(exitCode(err), err)
+	}
+	defer func() { _ = tr.Close() }()
+	table := &Table{Dimensions: tr.Dimensions}
+	for tr.Next() {
+		if err := table.Values.Append(tr.Row().Count); err != nil {
+			_ = table.Values.Close()
+			fatal(exitFailure, fmt.Errorf("spilling values to disk: %w", err))
+		}
+	}
+	if err := tr.Err(); err != nil {
+		_ = table.Values.Close()
+		fatal(exitCode(err), err)
+	}
+	if err := table.Values.Done(); err != nil {
+		_ = table.Values.Close()
+		fatal(exitFailure, fmt.Errorf("spilling values to disk: %w", err))
+	}
+	return table
+}
+
+// writeRuleReport writes the rule variable of the dataset and the disclosure control
+// status of the table, see cantabular.RuleReport, as JSON to the file at path.
+func writeRuleReport(client *cantabular.Client, path string) {
+	report, err := client.QueryRules(context.Background(), flag.Arg(0), flag.Args()[1:], filters)
+	if err != nil {
+		fatal(exitCode(err), err)
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		fatal(exitOutput, err)
+	}
 }
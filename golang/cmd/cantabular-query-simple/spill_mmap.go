@@ -0,0 +1,38 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"syscall"
+)
+
+// mmapSpill reads values from a memory mapped file.
+type mmapSpill []byte
+
+// openSpill maps the size bytes of values written to f. The mapping outlives the
+// file, so f is closed and removed once it is mapped.
+func openSpill(f *os.File, size int) (spill, error) {
+	defer func() { _ = f.Close(); _ = os.Remove(f.Name()) }()
+	if size == 0 {
+		return mmapSpill(nil), nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return mmapSpill(data), nil
+}
+
+func (s mmapSpill) at(i int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(s[i*valueSize:]))
+}
+
+func (s mmapSpill) close() error {
+	if s == nil {
+		return nil
+	}
+	return syscall.Munmap(s)
+}
@@ -0,0 +1,31 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris)
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+// fileSpill reads values from a file where memory mapping isn't available.
+type fileSpill struct{ f *os.File }
+
+// openSpill reads the values written to f, which is removed when the spill is
+// closed, as it can't be while open on Windows.
+func openSpill(f *os.File, _ int) (spill, error) {
+	return fileSpill{f}, nil
+}
+
+func (s fileSpill) at(i int) float64 {
+	var buf [valueSize]byte
+	if _, err := s.f.ReadAt(buf[:], int64(i*valueSize)); err != nil {
+		panic(err)
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+}
+
+func (s fileSpill) close() error {
+	_ = s.f.Close()
+	return os.Remove(s.f.Name())
+}
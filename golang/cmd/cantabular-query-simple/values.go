@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+// Values holds the cell values of a Table in row-major order.
+//
+// Small tables are held in memory. Once more than SpillThreshold values have been
+// appended they are written to a temporary file instead, as they are read from the
+// response, which on Unix-like systems is memory mapped so that the operating
+// system pages values in and out as needed. This lets programs handle tables larger
+// than memory, though more slowly.
+type Values struct {
+	floats []float64
+	f      *os.File // the file values are spilled to until Done
+	bw     *bufio.Writer
+	spill  spill
+	n      int
+}
+
+// SpillThreshold is the number of values above which Values are stored on disk.
+// Zero means never.
+var SpillThreshold = 0
+
+// spill is the storage of values written to disk, which removes the file when
+// closed if it hasn't already.
+type spill interface {
+	at(i int) float64
+	close() error
+}
+
+const valueSize = 8 // bytes per value on disk
+
+// Append adds the next value, which is fractional for weighted datasets, spilling
+// the values to disk if there are too many.
+func (v *Values) Append(x float64) error {
+	if v.f == nil && SpillThreshold > 0 && v.n == SpillThreshold {
+		f, err := os.CreateTemp("", "cantabular-values-*")
+		if err != nil {
+			return err
+		}
+		v.f, v.bw = f, bufio.NewWriter(f)
+		for _, x := range v.floats {
+			v.write(x)
+		}
+		v.floats = nil
+	}
+	v.n++
+	if v.f == nil {
+		v.floats = append(v.floats, x)
+		return nil
+	}
+	return v.write(x)
+}
+
+// write writes a value to the spill file. Errors are sticky, as for bufio.Writer.
+func (v *Values) write(x float64) error {
+	var buf [valueSize]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(x))
+	_, err := v.bw.Write(buf[:])
+	return err
+}
+
+// Done must be called once every value has been appended, before At.
+func (v *Values) Done() error {
+	if v.f == nil {
+		return nil
+	}
+	if err := v.bw.Flush(); err != nil {
+		return err
+	}
+	s, err := openSpill(v.f, v.n*valueSize)
+	if err != nil {
+		return err
+	}
+	v.f, v.bw, v.spill = nil, nil, s
+	return nil
+}
+
+// Len returns the number of values.
+func (v *Values) Len() int { return v.n }
+
+// At returns the i-th value.
+func (v *Values) At(i int) float64 {
+	if v.spill != nil {
+		return v.spill.at(i)
+	}
+	return v.floats[i]
+}
+
+// Close releases the disk storage of spilled values.
+func (v *Values) Close() error {
+	switch {
+	case v.spill != nil:
+		return v.spill.close()
+	case v.f != nil:
+		_ = v.f.Close()
+		return os.Remove(v.f.Name())
+	}
+	return nil
+}
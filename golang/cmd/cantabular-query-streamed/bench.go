@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// benchDecode benchmarks converting the response saved at path, using the current options,
+// both by decoding it whole as the simple example does and by streaming it, and reports the
+// time and allocations of each to stdout. This helps choose between the approaches for the
+// sizes of table a user works with.
+func benchDecode(path string) {
+	raw, err := readSavedResponse(path)
+	if err != nil {
+		panic(err)
+	}
+	for _, c := range []struct {
+		name    string
+		convert func(r io.Reader, w io.Writer)
+	}{
+		{"buffered (encoding/json)", bufferedJSONToCSV},
+		{"streamed (jsonstream)", graphqlJSONToCSV},
+	} {
+		// convert once first as panics in the benchmark goroutine can't be recovered
+		c.convert(bytes.NewReader(raw), io.Discard)
+		result := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				c.convert(bytes.NewReader(raw), io.Discard)
+			}
+		})
+		fmt.Printf("%-26s %s\t%s\n", c.name, result, result.MemString())
+	}
+}
+
+// bufferedJSONToCSV converts a JSON response in r to CSV on w like graphqlJSONToCSV, but
+// decodes the whole response into memory first. It panics on error.
+func bufferedJSONToCSV(r io.Reader, w io.Writer) {
+	dims, values := decodeTable(r)
+	if values == nil {
+		return
+	}
+	writeTable(&valuesSlice{values: values}, dims, w)
+}
+
+// valuesSlice provides the cellValues of a table held in memory.
+type valuesSlice struct {
+	values []value.Value
+	next   int
+}
+
+func (v *valuesSlice) More() bool { return v.next < len(v.values) }
+
+func (v *valuesSlice) DecodeValue() value.Value {
+	v.next++
+	return v.values[v.next-1]
+}
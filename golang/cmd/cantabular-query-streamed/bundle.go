@@ -0,0 +1,122 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bundle collects the outputs of a query to write them to a .zip -o as one
+// self-describing archive, so that a published table travels with its provenance.
+// The archive contains:
+//
+//	data.<format>  the table in each -format, e.g. data.csv
+//	schema.json    the description of its columns, as written by -schema
+//	manifest.json  the manifest of the run, as written by -manifest
+//	SHA256SUMS     the SHA-256 checksums of the other files, in the format of sha256sum
+type bundle struct {
+	path, schemaPath string // the -o and -schema given
+	dir              string // temporary directory of the outputs
+}
+
+// newBundle redirects the outputs of the query to a temporary directory until finish
+// is called. The caller must call remove once done.
+func newBundle() *bundle {
+	dir, err := os.MkdirTemp("", "cantabular-bundle-*")
+	if err != nil {
+		panic(outputError(err))
+	}
+	b := &bundle{path: *outputPath, schemaPath: *schemaPath, dir: dir}
+	*outputPath = filepath.Join(dir, "data")
+	if formats := outputFormats(); len(formats) == 1 {
+		*outputPath += "." + formats[0]
+	}
+	*schemaPath = filepath.Join(dir, "schema.json")
+	return b
+}
+
+// finish restores -o and -schema, copying the schema to any -schema given, and
+// writes the archive with the manifest of the run.
+func (b *bundle) finish(started time.Time, dataset, digest string, vars []string) {
+	*outputPath, *schemaPath = b.path, b.schemaPath
+	if *schemaPath != "" {
+		copyFile(*schemaPath, filepath.Join(b.dir, "schema.json"))
+	}
+	writeManifest(filepath.Join(b.dir, "manifest.json"), started, dataset, digest, vars)
+
+	data, err := filepath.Glob(filepath.Join(b.dir, "data.*"))
+	if err != nil {
+		panic(err)
+	}
+	sort.Strings(data)
+	names := make([]string, 0, len(data)+2)
+	for _, path := range data {
+		names = append(names, filepath.Base(path))
+	}
+	names = append(names, "schema.json", "manifest.json")
+
+	f, err := os.Create(b.path)
+	if err != nil {
+		panic(outputError(err))
+	}
+	zw := zip.NewWriter(f)
+	now := time.Now()
+	create := func(name string) io.Writer {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			panic(outputError(err))
+		}
+		return w
+	}
+	var sums strings.Builder
+	for _, name := range names {
+		w := create(name)
+		h := sha256.New()
+		copyFrom(io.MultiWriter(w, h), filepath.Join(b.dir, name))
+		fmt.Fprintf(&sums, "%x  %s\n", h.Sum(nil), name)
+	}
+	if _, err := io.WriteString(create("SHA256SUMS"), sums.String()); err != nil {
+		panic(outputError(err))
+	}
+	if err := zw.Close(); err != nil {
+		panic(outputError(err))
+	}
+	if err := f.Close(); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// remove removes the temporary directory of the outputs.
+func (b *bundle) remove() {
+	_ = os.RemoveAll(b.dir)
+}
+
+// copyFile copies the file at src to dst. It panics on error.
+func copyFile(dst, src string) {
+	f, err := os.Create(dst)
+	if err != nil {
+		panic(outputError(err))
+	}
+	copyFrom(f, src)
+	if err := f.Close(); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// copyFrom copies the file at path to w. It panics on error.
+func copyFrom(w io.Writer, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.Copy(w, f); err != nil {
+		panic(outputError(err))
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cacheEntry is a converted output stored in the -cache-dir directory.
+// Entries are content addressed: the file name is a hash of everything
+// which affects the output, including the digest of the dataset, so an
+// entry is never reused once the dataset changes.
+type cacheEntry struct {
+	path string
+	tmp  *os.File
+}
+
+// cacheNeutralFlags are the flags which do not affect the output. Any other
+// flag which was set is part of the cache key.
+var cacheNeutralFlags = map[string]bool{
+	"cache-dir":        true,
+	"cache-ttl":        true,
+	"cacert":           true,
+	"cert":             true,
+	"flush-every":      true,
+	"gzip":             true,
+	"history":          true,
+	"insecure":         true,
+	"key":              true,
+	"manifest":         true,
+	"max-bandwidth":    true,
+	"o":                true,
+	"parallel":         true,
+	"progress":         true,
+	"proxy":            true,
+	"retries":          true,
+	"retry-backoff":    true,
+	"retry-on":         true,
+	"refresh-metadata": true,
+	"rule-report":      true,
+	"save-response":    true,
+	"since-manifest":   true,
+	"schema":           true,
+	"split-after":      true,
+	"stats":            true,
+}
+
+// useCache returns whether -cache-dir may be used. The -schema file and renamed
+// headers for the manifest are produced as the response is converted, so cannot
+// be obtained from a cached output, and SQLite output and several formats are not
+// written to a single file. The -run-id-comment differs for every run, so would be
+// stale in a cached output.
+func useCache() bool {
+	return *cacheDir != "" && *schemaPath == "" && !(*snakeCaseHeaders && *manifestPath != "") &&
+		*format != "sqlite" && len(outputFormats()) == 1 && !*runIDComment
+}
+
+// lookupCache returns the cache entry for a query. If the dataset digest
+// cannot be obtained then a warning is printed and nil is returned, in which
+// case the cache should be bypassed.
+func lookupCache(ctx context.Context, dataset string, vars []string) *cacheEntry {
+	digest, err := datasetDigest(ctx, dataset)
+	if err != nil {
+		logf("WARNING", "not using cache: %s", err)
+		return nil
+	}
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", apiURLs, dataset, digest, strings.Join(vars, "\x00"))
+	flag.Visit(func(f *flag.Flag) {
+		if !cacheNeutralFlags[f.Name] {
+			_, _ = fmt.Fprintf(h, "-%s=%s\x00", f.Name, f.Value)
+		}
+	})
+	return &cacheEntry{path: filepath.Join(*cacheDir, hex.EncodeToString(h.Sum(nil))+".csv")}
+}
+
+// fresh returns true if the entry exists and is no older than -cache-ttl.
+func (e *cacheEntry) fresh() bool {
+	info, err := os.Stat(e.path)
+	return err == nil && time.Since(info.ModTime()) <= *cacheTTL
+}
+
+// copyTo writes the cached output to w.
+func (e *cacheEntry) copyTo(w io.Writer) {
+	f, err := os.Open(e.path)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = f.Close() }()
+	if _, err := io.Copy(w, f); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// create returns a temporary file in the cache directory to write the output to.
+// The output only becomes the cache entry when commit is called.
+func (e *cacheEntry) create() io.Writer {
+	if err := os.MkdirAll(*cacheDir, 0o755); err != nil {
+		panic(outputError(err))
+	}
+	tmp, err := os.CreateTemp(*cacheDir, ".tmp-*")
+	if err != nil {
+		panic(outputError(err))
+	}
+	e.tmp = tmp
+	return tmp
+}
+
+// commit atomically replaces the cache entry with the output written since create.
+func (e *cacheEntry) commit() {
+	if err := e.tmp.Close(); err != nil {
+		panic(outputError(err))
+	}
+	if err := os.Rename(e.tmp.Name(), e.path); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// discard removes the temporary file if the output was not committed.
+func (e *cacheEntry) discard() {
+	_ = e.tmp.Close()
+	_ = os.Remove(e.tmp.Name())
+}
+
+// datasetDigest obtains the digest of the dataset, which changes whenever its data does.
+func datasetDigest(ctx context.Context, dataset string) (digest string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("obtaining dataset digest: %s", r)
+		}
+	}()
+	const graphQLQuery = `
+query($dataset: String!) {
+ dataset(name: $dataset) { digest }
+}`
+	var data struct {
+		Dataset struct{ Digest string }
+	}
+	queryData(ctx, graphQLQuery, map[string]interface{}{"dataset": dataset}, &data)
+	return data.Dataset.Digest, nil
+}
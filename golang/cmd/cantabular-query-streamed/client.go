@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// newClient returns the client of the API at the -u endpoints, failing over between
+// them, which sends its requests with rt. Each request is identified by the runID in
+// an X-Request-ID header, unless -H gives one.
+func newClient(rt http.RoundTripper) *cantabular.Client {
+	header := http.Header{"X-Request-Id": {runID}}
+	cantabular.SetHeaders(header, http.Header(customHeaders))
+	return cantabular.NewClient(apiURLs.urls[0],
+		cantabular.WithHTTPClient(&http.Client{Transport: &failoverTransport{base: rt, endpoints: apiURLs}}),
+		cantabular.WithAuthToken(*authToken, *apiKeyHeader),
+		cantabular.WithHeaders(header),
+		cantabular.WithHooks(requestHeaderHooks{}))
+}
+
+type requestHeaderKey struct{}
+
+// withRequestHeader returns ctx with headers to be sent with the request made with it
+// in addition to those of every request, see requestHeaderHooks.
+func withRequestHeader(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, requestHeaderKey{}, header)
+}
+
+// streamedHeader returns the header of a request whose response body is streamed,
+// which is requested gzip compressed, see gunzipped.
+func streamedHeader() http.Header {
+	return http.Header{"Accept-Encoding": {"gzip"}}
+}
+
+// requestHeaderHooks set the headers given by withRequestHeader, apart from those
+// which -H gives.
+type requestHeaderHooks struct{}
+
+func (requestHeaderHooks) OnRequest(req *http.Request) {
+	header, _ := req.Context().Value(requestHeaderKey{}).(http.Header)
+	for name, values := range header {
+		if _, ok := customHeaders[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+}
+
+func (requestHeaderHooks) OnResponse(*http.Request, *http.Response, error) {}
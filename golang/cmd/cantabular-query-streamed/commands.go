@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/cantabular/examples/pkg/jsonstream"
+)
+
+// subcommands are the commands of the program by name. A command line not beginning
+// with one of them is a query, as it was before there were subcommands, so a dataset
+// named after a subcommand must be queried with an explicit "query".
+var subcommands = map[string]func(ctx context.Context, args []string){
+	"query":    queryCommand,
+	"metadata": metadataCommand,
+	"codebook": codebookCommand,
+	"datasets": datasetsCommand,
+	"history":  historyCommand,
+	"rerun":    rerunCommand,
+	"lint":     lintCommand,
+}
+
+// metadataCommand writes the name, label, description and digest of the dataset in args as JSON.
+func metadataCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	const graphQLQuery = `
+query($dataset: String!) {
+ dataset(name: $dataset) { name label description digest }
+}`
+	var data struct {
+		Dataset struct {
+			Name        string `json:"name"`
+			Label       string `json:"label"`
+			Description string `json:"description"`
+			Digest      string `json:"digest"`
+		}
+	}
+	queryData(ctx, graphQLQuery, map[string]interface{}{"dataset": args[0]}, &data)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data.Dataset); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// codebookCommand writes the categories of every variable of the dataset in args
+// with a row for each category, as CSV or with -format json as JSON. The categories
+// are written as they are received, so variables with very many categories are not
+// held in memory.
+func codebookCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	const graphQLQuery = `
+query($dataset: String!) {
+ dataset(name: $dataset) {
+  variables { edges { node { name label categories { edges { node { code label } } } } } }
+ }
+}`
+	resp := postQuery(ctx, graphQLQuery, map[string]interface{}{"dataset": args[0]})
+	defer func() { _ = resp.Body.Close() }()
+	decompressBody(resp)
+	lw := newListingWriter([]string{"variable", "variable_label", "code", "label"})
+	defer lw.close()
+
+	dec := jsonstream.New(resp.Body)
+	// fields decodes the fields of an object, calling the function for each name
+	// with the decoder positioned at its value and skipping any others
+	fields := func(fns map[string]func()) {
+		if !dec.StartObjectComposite() {
+			return
+		}
+		for dec.More() {
+			if fn := fns[dec.DecodeName()]; fn != nil {
+				fn()
+			} else {
+				dec.SkipValue()
+			}
+		}
+		dec.EndComposite()
+	}
+	variable := func() {
+		var name, label string
+		fields(map[string]func(){
+			"name":  func() { name = *dec.DecodeString() },
+			"label": func() { label = *dec.DecodeString() },
+			"categories": func() {
+				if name == "" {
+					panic("categories received before variable name")
+				}
+				fields(map[string]func(){"edges": func() {
+					type edge struct{ Node struct{ Code, Label string } }
+					_ = jsonstream.StreamArray(dec, func(e edge) error {
+						lw.write([]string{name, label, e.Node.Code, e.Node.Label})
+						return nil
+					})
+				}})
+			},
+		})
+	}
+	fields(map[string]func(){
+		"data": func() {
+			fields(map[string]func(){"dataset": func() {
+				fields(map[string]func(){"variables": func() {
+					fields(map[string]func(){"edges": func() {
+						_ = jsonstream.DecodeArrayFunc(dec, func(jsonstream.Decoder) error {
+							fields(map[string]func(){"node": variable})
+							return nil
+						})
+					}})
+				}})
+			}})
+		},
+		"errors": func() { decodeErrorsPanicIfAny(dec) },
+	})
+}
+
+// datasetsCommand writes the name, label and description of each dataset as CSV.
+func datasetsCommand(ctx context.Context, args []string) {
+	if len(args) != 0 {
+		usage()
+	}
+	const graphQLQuery = `
+query {
+ datasets { name label description }
+}`
+	var data struct {
+		Datasets []struct{ Name, Label, Description string }
+	}
+	queryData(ctx, graphQLQuery, nil, &data)
+	lw := newListingWriter([]string{"name", "label", "description"})
+	defer lw.close()
+	for _, d := range data.Datasets {
+		lw.write([]string{d.Name, d.Label, d.Description})
+	}
+}
+
+// listingWriter writes rows to stdout (or -o) as CSV with a header, or with -format
+// json as an array of objects with the header as keys, one row at a time.
+type listingWriter struct {
+	header   []string
+	out      io.Writer
+	closeOut func()
+	cw       recordWriter
+	rows     int
+}
+
+// newListingWriter returns a listingWriter for rows with the header. It panics if
+// -format is not csv or json.
+func newListingWriter(header []string) *listingWriter {
+	if *format != "csv" && *format != "json" {
+		panic(usageError("unknown -format %q for a listing, expected csv or json", *format))
+	}
+	lw := &listingWriter{header: header}
+	lw.out, lw.closeOut = openOutput()
+	if *format == "csv" {
+		cw, err := csvStyle.newRecordWriter(lw.out)
+		if err != nil {
+			panic(outputError(err))
+		}
+		lw.cw = cw
+		_ = lw.cw.Write(header)
+	}
+	return lw
+}
+
+// write writes a row, which must have a value for each column of the header.
+func (lw *listingWriter) write(row []string) {
+	if lw.cw != nil {
+		_ = lw.cw.Write(row) // errors are sticky, so are checked by close
+		return
+	}
+	var b bytes.Buffer
+	if lw.rows == 0 {
+		b.WriteString("[\n  {")
+	} else {
+		b.WriteString(",\n  {")
+	}
+	for i, h := range lw.header {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		k, _ := json.Marshal(h)
+		v, _ := json.Marshal(row[i])
+		b.Write(k)
+		b.WriteString(": ")
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	if _, err := lw.out.Write(b.Bytes()); err != nil {
+		panic(outputError(err))
+	}
+	lw.rows++
+}
+
+// close completes the listing and closes the output. It panics on error.
+func (lw *listingWriter) close() {
+	defer lw.closeOut()
+	if lw.cw != nil {
+		lw.cw.Flush()
+		if err := lw.cw.Error(); err != nil {
+			panic(outputError(err))
+		}
+		return
+	}
+	end := "\n]\n"
+	if lw.rows == 0 {
+		end = "[]\n"
+	}
+	if _, err := io.WriteString(lw.out, end); err != nil {
+		panic(outputError(err))
+	}
+}
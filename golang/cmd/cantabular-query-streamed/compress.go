@@ -0,0 +1,71 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Responses whose bodies are streamed, such as tables, are requested gzip compressed,
+// as tables compress well, see streamedHeader. The Accept-Encoding header is set
+// explicitly rather than left to http.Transport, which would decompress the body itself
+// and hide the Content-Length, so that -progress, -max-bandwidth and -stats measure the
+// bytes transferred, and the body is decompressed after them. The smaller responses
+// decoded by cantabular.Client are decompressed by http.Transport.
+
+// gunzipped returns a reader of r decompressed if the header says it is gzip compressed.
+func gunzipped(r io.Reader, header http.Header) io.Reader {
+	if !strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		return r
+	}
+	return &gunzipReader{r: r}
+}
+
+// decompressBody replaces the body of resp with its decompressed content, for
+// responses which are not measured as they are read.
+func decompressBody(resp *http.Response) {
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{gunzipped(resp.Body, resp.Header), resp.Body}
+}
+
+// gunzipReader decompresses r, reading the gzip header on the first Read rather
+// than when it is created so that errors are returned by Read.
+type gunzipReader struct {
+	r  io.Reader
+	zr *gzip.Reader
+}
+
+func (g *gunzipReader) Read(p []byte) (int, error) {
+	if g.zr == nil {
+		zr, err := gzip.NewReader(g.r)
+		if err != nil {
+			return 0, err
+		}
+		g.zr = zr
+	}
+	return g.zr.Read(p)
+}
+
+// gzipOutput returns whether the output is to be gzip compressed, as by -gzip or an
+// -o ending in .gz.
+func gzipOutput() bool {
+	return *gzipFlag || strings.HasSuffix(*outputPath, ".gz")
+}
+
+// compressOutput returns w gzip compressed if gzipOutput, and a function to close it
+// which completes the compressed stream before calling closeW. It panics on error.
+func compressOutput(w io.Writer, closeW func()) (io.Writer, func()) {
+	if !gzipOutput() {
+		return w, closeW
+	}
+	zw := gzip.NewWriter(w)
+	return zw, func() {
+		if err := zw.Close(); err != nil {
+			panic(outputError(err))
+		}
+		closeW()
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+func init() {
+	sink.Register("csv", func(w io.Writer) sink.Sink { return &csvSink{w: w, dialect: csvStyle} })
+}
+
+// csvSink writes CSV in the -dialect with a header row of the column names, preceded
+// with -run-id-comment by a comment identifying the run.
+type csvSink struct {
+	w       io.Writer
+	dialect csvDialect
+	cw      recordWriter
+	record  []string
+}
+
+func (s *csvSink) Open(meta sink.Metadata) error {
+	cw, err := s.dialect.newRecordWriter(s.w)
+	if err != nil {
+		return err
+	}
+	s.cw = cw
+	if *runIDComment {
+		// nothing has been written by the record writer yet, so the comment precedes the header
+		if _, err := io.WriteString(s.w, "# run-id: "+runID+s.dialect.eol()); err != nil {
+			return err
+		}
+	}
+	header := make([]string, 0, len(meta.Columns))
+	for _, c := range meta.Columns {
+		header = append(header, c.Name)
+	}
+	return s.cw.Write(header)
+}
+
+func (s *csvSink) WriteRow(row []value.Value) error {
+	s.record = s.record[:0]
+	for _, v := range row {
+		s.record = append(s.record, s.dialect.field(v))
+	}
+	return s.cw.Write(s.record)
+}
+
+func (s *csvSink) Flush() error {
+	s.cw.Flush()
+	return s.cw.Error()
+}
+
+func (s *csvSink) Close() error {
+	return s.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// bufferedMaxCells is the largest table which -decode auto decodes whole rather than streaming.
+const bufferedMaxCells = 100000
+
+// chooseConverter returns the function which converts the response to the output
+// according to -decode. Decoding the whole response before writing anything means
+// that no partial output is written if there's an error, so auto chooses it for
+// tables small enough to hold in memory. The number of cells is found by a
+// preflight query; if that fails, or the table is not from the extended API,
+// then the response is streamed.
+func chooseConverter(ctx context.Context, dataset string, vars []string) func(r io.Reader, w io.Writer) {
+	switch *decodeStrategy {
+	case "buffered":
+		return bufferedJSONToCSV
+	case "streamed":
+		return graphqlJSONToCSV
+	case "auto":
+		if *sourceSpec != "http" {
+			// the preflight query is of the extended API, not the source
+			return graphqlJSONToCSV
+		}
+		cells, err := expectedCells(ctx, dataset, vars)
+		if err != nil {
+			logf("WARNING", "streaming as the table size is unknown: %s", err)
+			return graphqlJSONToCSV
+		}
+		if cells <= bufferedMaxCells {
+			return bufferedJSONToCSV
+		}
+		return graphqlJSONToCSV
+	}
+	panic(usageError("unknown -decode %q, expected buffered, streamed or auto", *decodeStrategy))
+}
+
+// expectedCells returns the number of cells in the table of vars, which is the product
+// of the number of categories of each variable.
+func expectedCells(ctx context.Context, dataset string, vars []string) (cells int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s", r)
+		}
+	}()
+	const graphQLQuery = `
+query($dataset: String!, $variables: [String!]!) {
+ dataset(name: $dataset) {
+  variables(names: $variables) {
+   edges { node { categories { totalCount } } }
+  }
+ }
+}`
+	var data struct {
+		Dataset struct {
+			Variables struct {
+				Edges []struct {
+					Node struct {
+						Categories struct{ TotalCount int }
+					}
+				}
+			}
+		}
+	}
+	queryData(ctx, graphQLQuery, map[string]interface{}{
+		"dataset":   dataset,
+		"variables": vars,
+	}, &data)
+	cells = 1
+	for _, v := range data.Dataset.Variables.Edges {
+		cells *= v.Node.Categories.TotalCount
+	}
+	return cells, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// csvDialect is a preset of the choices made in writing CSV, selected by -dialect,
+// so that they are made consistently for the program which is to read the output.
+type csvDialect struct {
+	comma        rune
+	crlf         bool // end lines with CRLF rather than LF
+	bom          bool // begin with a UTF-8 byte order mark, from which Excel detects the encoding
+	noQuotes     bool // replace tabs and line breaks in fields with spaces rather than quote fields
+	decimalComma bool // write the decimal separator of fractional values as a comma
+	comments     bool // its readers may skip comment lines, see -run-id-comment
+}
+
+// csvDialects are the presets of -dialect. The default is that of encoding/csv.
+var csvDialects = map[string]csvDialect{
+	"":         {comma: ',', comments: true},
+	"rfc4180":  {comma: ',', crlf: true},
+	"excel-eu": {comma: ';', crlf: true, bom: true, decimalComma: true},
+	"tsv":      {comma: '\t', noQuotes: true, comments: true},
+}
+
+// csvStyle is the dialect of CSV output, set from -dialect by main.
+var csvStyle = csvDialects[""]
+
+// recordWriter writes records of fields. Errors are sticky, so may be checked once
+// by Error after Flush.
+type recordWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// newRecordWriter returns a writer of records to w in the dialect, having written
+// any byte order mark.
+func (d csvDialect) newRecordWriter(w io.Writer) (recordWriter, error) {
+	if d.bom {
+		if _, err := io.WriteString(w, "\uFEFF"); err != nil {
+			return nil, err
+		}
+	}
+	if d.noQuotes {
+		return &unquotedWriter{w: bufio.NewWriter(w), comma: string(d.comma), eol: d.eol()}, nil
+	}
+	cw := csv.NewWriter(w)
+	cw.Comma, cw.UseCRLF = d.comma, d.crlf
+	return cw, nil
+}
+
+// field returns v as written in a field of the dialect.
+func (d csvDialect) field(v value.Value) string {
+	if d.decimalComma && v.Kind() == value.Float {
+		return strings.Replace(v.String(), ".", ",", 1)
+	}
+	return v.String()
+}
+
+func (d csvDialect) eol() string {
+	if d.crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// unquotedWriter writes records with fields separated by comma and never quoted, as
+// for tab-separated values. Any comma or line break in a field is replaced with a
+// space so that the fields and lines of the output are as written.
+type unquotedWriter struct {
+	w       *bufio.Writer
+	comma   string
+	eol     string
+	cleaner *strings.Replacer
+	err     error
+}
+
+func (u *unquotedWriter) Write(record []string) error {
+	if u.err != nil {
+		return u.err
+	}
+	if u.cleaner == nil {
+		u.cleaner = strings.NewReplacer(u.comma, " ", "\r\n", " ", "\r", " ", "\n", " ")
+	}
+	for i, field := range record {
+		if i > 0 {
+			_, _ = u.w.WriteString(u.comma)
+		}
+		_, _ = u.cleaner.WriteString(u.w, field)
+	}
+	_, u.err = u.w.WriteString(u.eol) // bufio.Writer errors are sticky
+	return u.err
+}
+
+func (u *unquotedWriter) Flush() {
+	if err := u.w.Flush(); u.err == nil {
+		u.err = err
+	}
+}
+
+func (u *unquotedWriter) Error() error { return u.err }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// The exit codes of the classes of failure, so that scripts and schedulers can react
+// to each, e.g. by retrying network failures but not blocked tables.
+const (
+	exitFailure = 1 // any other error
+	exitEmpty   = 2 // a table has no cells, see writeTable
+	exitUsage   = 3 // the command line is invalid
+	exitNetwork = 4 // the API could not be reached, or did not respond with 200 OK
+	exitGraphQL = 5 // the API reported GraphQL errors, e.g. for an unknown variable
+	exitBlocked = 6 // the table was blocked by disclosure control
+	exitOutput  = 7 // the output could not be written
+)
+
+// exitError is an error of a class of failure with its own exit code. Errors are
+// panicked as they are elsewhere in the command, and main exits with the code of
+// the error it recovers, see exitCode.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+func (e *exitError) Unwrap() error { return e.err }
+
+// usageError returns an error in the command line, as for fmt.Errorf.
+func usageError(format string, a ...interface{}) error {
+	return &exitError{exitUsage, fmt.Errorf(format, a...)}
+}
+
+// statusError returns the error of a response which is not 200 OK.
+func statusError(status string) error {
+	return &exitError{exitNetwork, errors.New(status)}
+}
+
+// graphQLError returns the error of the GraphQL errors in a response.
+func graphQLError(msg string) error {
+	return &exitError{exitGraphQL, errors.New(msg)}
+}
+
+// blockedError returns the error of a table blocked with the message msg.
+func blockedError(msg string) error {
+	return &exitError{exitBlocked, fmt.Errorf("Table blocked: %s", msg)}
+}
+
+// outputError returns err, from writing the output, as an error of that class.
+func outputError(err error) error {
+	return &exitError{exitOutput, err}
+}
+
+// apiError returns err, from a request of the cantabular client, as an error of its
+// class: a status other than 200 OK, or GraphQL errors. Other errors are returned
+// unchanged, see exitCode.
+func apiError(err error) error {
+	var statusErr *cantabular.StatusError
+	var gqlErr *cantabular.GraphQLError
+	switch {
+	case errors.As(err, &statusErr):
+		return statusError(statusErr.Status)
+	case errors.As(err, &gqlErr):
+		return graphQLError(gqlErr.Error())
+	}
+	return err
+}
+
+// exitCode returns the exit code of a value recovered from a panic. Errors of
+// requests which could not be made, such as failures to connect, are network
+// failures whether or not they were classified when they were panicked.
+func exitCode(recovered interface{}) int {
+	err, _ := recovered.(error)
+	var exitErr *exitError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.As(err, &netErr):
+		return exitNetwork
+	}
+	return exitFailure
+}
+
+// usage prints the usage of the command and exits with exitUsage.
+func usage() {
+	flag.Usage()
+	os.Exit(exitUsage)
+}
+
+// parseFlags parses the flags in args, exiting with exitUsage if they are invalid,
+// as the flag package has reported why, or successfully if -h was given.
+func parseFlags(args []string) {
+	if err := flag.CommandLine.Parse(args); errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+}
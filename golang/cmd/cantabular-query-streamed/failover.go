@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// failureCooldown is how long an endpoint which failed is tried only after all healthy endpoints.
+const failureCooldown = 30 * time.Second
+
+// endpoints is a list of extended API URLs which serve the same datasets,
+// for example replicas behind different hosts. It implements flag.Value:
+// the flag may be repeated or given a comma-separated list. The mutex guards
+// the health of the endpoints and the rotation of -round-robin, as requests
+// are made at once, e.g. by categoryCodes.
+type endpoints struct {
+	urls     []string
+	mu       sync.Mutex
+	failedAt []time.Time
+	next     int
+	set      bool
+}
+
+func newEndpoints(urls ...string) *endpoints {
+	return &endpoints{urls: urls, failedAt: make([]time.Time, len(urls))}
+}
+
+func (e *endpoints) String() string { return strings.Join(e.urls, ",") }
+
+// Set replaces the default URL on first use and appends on subsequent uses.
+func (e *endpoints) Set(s string) error {
+	if !e.set {
+		e.urls, e.set = nil, true
+	}
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			e.urls = append(e.urls, u)
+		}
+	}
+	if len(e.urls) == 0 {
+		return fmt.Errorf("no URL given")
+	}
+	e.failedAt = make([]time.Time, len(e.urls))
+	return nil
+}
+
+// order returns the indices of the endpoints in the order in which they should be tried.
+// Endpoints which have failed recently come after the healthy ones, otherwise the order
+// is as given on the command line or, with -round-robin, rotated for each request.
+func (e *endpoints) order() []int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	indices := make([]int, len(e.urls))
+	for i := range indices {
+		indices[i] = (e.next + i) % len(e.urls)
+	}
+	if *roundRobin {
+		e.next = (e.next + 1) % len(e.urls)
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return !e.failedRecently(indices[a]) && e.failedRecently(indices[b])
+	})
+	return indices
+}
+
+// failedRecently returns whether the endpoint failed within failureCooldown. The
+// caller must hold the mutex.
+func (e *endpoints) failedRecently(i int) bool {
+	return time.Since(e.failedAt[i]) < failureCooldown
+}
+
+// failed records that the endpoint could not be reached or was unavailable.
+func (e *endpoints) failed(i int, err error) {
+	e.mu.Lock()
+	e.failedAt[i] = time.Now()
+	e.mu.Unlock()
+	if len(e.urls) > 1 {
+		logf("WARNING", "failing over from %s: %s", e.urls[i], err)
+	}
+}
+
+// succeeded records that the endpoint is healthy.
+func (e *endpoints) succeeded(i int) {
+	e.mu.Lock()
+	e.failedAt[i] = time.Time{}
+	e.mu.Unlock()
+}
+
+// failoverTransport sends each request to the endpoints in turn, in the order given by
+// endpoints.order, until one is available, replacing the URL of the request with that
+// of the endpoint. The response of the last endpoint is returned whatever its status.
+// With -stats, the timings of the request to the endpoint which answered are reported
+// once its body is closed.
+type failoverTransport struct {
+	base      http.RoundTripper
+	endpoints *endpoints
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	order := t.endpoints.order()
+	for n, i := range order {
+		u, err := url.Parse(t.endpoints.urls[i])
+		if err != nil {
+			return nil, err
+		}
+		r := req.Clone(req.Context())
+		r.URL, r.Host = u, ""
+		if n > 0 && req.Body != nil {
+			// the requests of cantabular.Client may be sent again
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		var stats *transferStats
+		if *showStats {
+			stats = &transferStats{}
+			r = stats.traceRequest(r)
+		}
+		resp, err := t.base.RoundTrip(r)
+		if err == nil && !unavailable(resp.StatusCode) {
+			t.endpoints.succeeded(i)
+		} else if n < len(order)-1 {
+			if err == nil {
+				err = fmt.Errorf("%s", resp.Status)
+				_ = resp.Body.Close()
+			}
+			t.endpoints.failed(i, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			stats.countBody(resp)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no extended API URL")
+}
+
+// unavailable returns true for the status codes which indicate that
+// another endpoint should be tried.
+func unavailable(statusCode int) bool {
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/source"
+)
+
+// filter restricts a variable to the given category codes in a table query.
+type filter = source.Filter
+
+// filters is a flag.Value for filters given as <var>=<code>,<code>... The flag may be repeated.
+type filters []filter
+
+func (fs *filters) String() string {
+	if fs == nil {
+		return ""
+	}
+	s := make([]string, 0, len(*fs))
+	for _, f := range *fs {
+		s = append(s, f.Variable+"="+strings.Join(f.Codes, ","))
+	}
+	return strings.Join(s, " ")
+}
+
+func (fs *filters) Set(s string) error {
+	variable, codes, ok := strings.Cut(s, "=")
+	if !ok || variable == "" || codes == "" {
+		return fmt.Errorf("expected <var>=<code>,<code>... but got %q", s)
+	}
+	*fs = append(*fs, filter{Variable: variable, Codes: strings.Split(codes, ",")})
+	return nil
+}
+
+// codes returns the codes variable is restricted to, or nil if it is not filtered.
+func (fs filters) codes(variable string) []string {
+	for _, f := range fs {
+		if f.Variable == variable {
+			return f.Codes
+		}
+	}
+	return nil
+}
+
+// with returns the filters with variable restricted to codes instead.
+func (fs filters) with(variable string, codes []string) filters {
+	with := filters{{Variable: variable, Codes: codes}}
+	for _, f := range fs {
+		if f.Variable != variable {
+			with = append(with, f)
+		}
+	}
+	return with
+}
+
+// variableCategories is a variable and the codes of its categories.
+type variableCategories struct {
+	Name  string
+	Codes []string
+}
+
+// categoryCodes returns the codes of the categories of each of vars which exists, in
+// the order of vars. The variables whose categories are not in the metadata cache are
+// requested with cantabular.Client.Categories, -parallel at once, once those which
+// don't exist are found from the names of the variables of the dataset.
+func categoryCodes(ctx context.Context, dataset string, vars []string) []variableCategories {
+	cache := openMetadataCache(ctx, dataset)
+	found := make([][]variableCategories, len(vars))
+	var missing []int // indices of the variables which aren't cached
+	for i, v := range vars {
+		var ok bool
+		if found[i], ok = cache.load(v); !ok {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) > 0 {
+		names, err := client.VariableNames(ctx, dataset)
+		if err != nil {
+			panic(apiError(err))
+		}
+		exists := make(map[string]bool, len(names))
+		for _, name := range names {
+			exists[name] = true
+		}
+		var query []string
+		var queried []int
+		for _, i := range missing {
+			if exists[vars[i]] {
+				query, queried = append(query, vars[i]), append(queried, i)
+			} else {
+				cache.store(vars[i], nil)
+			}
+		}
+		vcs, err := client.Categories(ctx, dataset, query, *parallel)
+		if err != nil {
+			panic(apiError(err))
+		}
+		for k, v := range vcs {
+			vc := variableCategories{Name: v.Name}
+			for _, c := range v.Categories {
+				vc.Codes = append(vc.Codes, c.Code)
+			}
+			i := queried[k]
+			found[i] = []variableCategories{vc}
+			cache.store(vars[i], found[i])
+		}
+	}
+	vcs := make([]variableCategories, 0, len(vars))
+	for _, f := range found {
+		vcs = append(vcs, f...)
+	}
+	return vcs
+}
+
+// checkFilters verifies that every code in the filters is a category of its variable,
+// as the API returns an empty or erroring table otherwise. It panics listing any
+// unknown codes along with similar codes which may have been meant.
+func checkFilters(ctx context.Context, dataset string, fs filters) {
+	names := make([]string, 0, len(fs))
+	for _, f := range fs {
+		names = append(names, f.Variable)
+	}
+	known := make(map[string][]string)
+	for _, vc := range categoryCodes(ctx, dataset, names) {
+		known[vc.Name] = vc.Codes
+	}
+	if problems := filterProblems(fs, known); len(problems) > 0 {
+		panic(usageError("invalid -filter:\n  %s", strings.Join(problems, "\n  ")))
+	}
+}
+
+// filterProblems returns the problems with the filters given the codes of the
+// categories of the variables which exist, listing any unknown variables and codes
+// along with similar codes which may have been meant.
+func filterProblems(fs filters, known map[string][]string) []string {
+	var problems []string
+	for _, f := range fs {
+		codes, ok := known[f.Variable]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: no such variable", f.Variable))
+			continue
+		}
+		valid := make(map[string]bool, len(codes))
+		for _, c := range codes {
+			valid[c] = true
+		}
+		for _, c := range f.Codes {
+			if valid[c] {
+				continue
+			}
+			problem := fmt.Sprintf("%s: unknown code %q", f.Variable, c)
+			if similar := similarCodes(c, codes); len(similar) > 0 {
+				problem += fmt.Sprintf(" (did you mean %s?)", strings.Join(similar, " or "))
+			}
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// similarCodes returns up to three quoted codes closest to code by edit distance,
+// ignoring case, provided they are close enough to be plausible typos.
+func similarCodes(code string, codes []string) []string {
+	type candidate struct {
+		code     string
+		distance int
+	}
+	var candidates []candidate
+	maxDistance := (len(code) + 1) / 3
+	for _, c := range codes {
+		if d := editDistance(strings.ToLower(code), strings.ToLower(c)); d <= maxDistance {
+			candidates = append(candidates, candidate{c, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+	var similar []string
+	for i := 0; i < len(candidates) && i < 3; i++ {
+		similar = append(similar, fmt.Sprintf("%q", candidates[i].code))
+	}
+	return similar
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+const (
+	// valueBatchSize is the number of values passed to an encoder at a time.
+	valueBatchSize = 1024
+	// queuedBatches is the number of batches which may wait for each encoder
+	// before decoding blocks until it catches up.
+	queuedBatches = 16
+)
+
+// outputFormats returns the formats given by -format, which may list several.
+func outputFormats() []string {
+	return strings.Split(*format, ",")
+}
+
+// formatPath returns the file to which the format is written when encoding several:
+// -o with its extension replaced by the format.
+func formatPath(format string) string {
+	return strings.TrimSuffix(*outputPath, filepath.Ext(*outputPath)) + "." + format
+}
+
+// writeFormats writes the table in each of the formats to its own file, see formatPath.
+// The values are decoded once and passed in batches to an encoder for each format
+// running concurrently, so producing several formats takes little longer than the
+// slowest of them. The queue of each encoder is bounded, so decoding never runs far
+// ahead of the slowest encoder and memory use is bounded whatever the size of the table.
+func writeFormats(values cellValues, dims table.Dimensions, formats []string) {
+	seen := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		switch {
+		case !sink.Registered(format):
+			panic(usageError("unknown -format %q, expected one of %v", format, sink.Names()))
+		case seen[format]:
+			panic(usageError("-format %s is repeated", format))
+		}
+		seen[format] = true
+	}
+	queues := make([]chan []value.Value, len(formats))
+	errs := make([]error, len(formats))
+	var wg sync.WaitGroup
+	for i, format := range formats {
+		queues[i] = make(chan []value.Value, queuedBatches)
+		wg.Add(1)
+		go func(i int, format string) {
+			path := formatPath(format)
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					if err, ok := r.(error); ok {
+						errs[i] = fmt.Errorf("%s: %w", path, err)
+					} else {
+						errs[i] = fmt.Errorf("%s: %v", path, r)
+					}
+					for range queues[i] {
+						// discard the remaining values so that decoding is not blocked
+					}
+				}
+			}()
+			encodeFile(format, &queuedValues{queue: queues[i]}, dims, path)
+			for range queues[i] {
+				// discard any values beyond -limit, so that decoding is not blocked
+			}
+		}(i, format)
+	}
+
+	batch := make([]value.Value, 0, valueBatchSize)
+	send := func() {
+		for _, q := range queues {
+			q <- batch // each encoder only reads the batch, so it may be shared
+		}
+		batch = make([]value.Value, 0, valueBatchSize)
+	}
+	// the encoders stop reading once -limit rows are written, see atLimit
+	for values.More() && !limitReached.Load() {
+		if batch = append(batch, values.DecodeValue()); len(batch) == valueBatchSize {
+			send()
+		}
+	}
+	if len(batch) > 0 {
+		send()
+	}
+	for _, q := range queues {
+		close(q)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		var exitErr *exitError
+		switch {
+		case errors.As(err, &exitErr):
+			panic(err) // e.g. a usage error, which keeps its exit code
+		case err != nil:
+			panic(outputError(err))
+		}
+	}
+}
+
+// encodeFile writes the table in the format to the file at path.
+func encodeFile(format string, values cellValues, dims table.Dimensions, path string) {
+	if format == "sqlite" {
+		encode(format, values, dims, nil, path)
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		panic(outputError(err))
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			panic(outputError(err))
+		}
+	}()
+	encode(format, values, dims, f, path)
+}
+
+// queuedValues are the cellValues received in batches from a queue.
+type queuedValues struct {
+	queue <-chan []value.Value
+	batch []value.Value
+}
+
+func (q *queuedValues) More() bool {
+	for len(q.batch) == 0 {
+		batch, ok := <-q.queue
+		if !ok {
+			return false
+		}
+		q.batch = batch
+	}
+	return true
+}
+
+func (q *queuedValues) DecodeValue() value.Value {
+	v := q.batch[0]
+	q.batch = q.batch[1:]
+	return v
+}
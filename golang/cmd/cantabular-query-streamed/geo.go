@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// geoRule recognises geography variables by name and extracts standard identifiers,
+// such as GSS codes, from the codes or labels of their categories, so that outputs
+// can be joined to boundary files without splitting combined values downstream.
+// Each named group of the pattern becomes a column <variable>_<group>, e.g. for
+//
+//	{"variables": "^(ltla|utla)$", "from": "label", "pattern": "^(?P<gss_code>[EKNSW]\\d{8}) (?P<name>.+)$"}
+//
+// a category of ltla labelled "E06000001 Hartlepool" has an ltla_gss_code column
+// of E06000001 and an ltla_name column of Hartlepool.
+type geoRule struct {
+	Variables string `json:"variables"` // regular expression matching the names of the variables
+	From      string `json:"from"`      // code or label, which the pattern is matched against
+	Pattern   string `json:"pattern"`   // regular expression with a named group for each column
+
+	variables, pattern *regexp.Regexp
+}
+
+var (
+	geoRulesOnce   sync.Once
+	loadedGeoRules []geoRule
+)
+
+// geoRules returns the rules of the -geo-rules file, read once, as several formats
+// may be encoded at once.
+func geoRules() []geoRule {
+	geoRulesOnce.Do(func() {
+		if *geoRulesPath != "" {
+			loadedGeoRules = readGeoRules(*geoRulesPath)
+		}
+	})
+	return loadedGeoRules
+}
+
+// readGeoRules reads the JSON array of rules at path. It panics on error.
+func readGeoRules(path string) []geoRule {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		panic(usageError("-geo-rules: %w", err))
+	}
+	var rules []geoRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		panic(usageError("-geo-rules %s: %w", path, err))
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.From != "code" && r.From != "label" {
+			panic(usageError("-geo-rules %s: rule %d: from must be code or label, not %q", path, i+1, r.From))
+		}
+		if r.variables, err = regexp.Compile(r.Variables); err == nil {
+			r.pattern, err = regexp.Compile(r.Pattern)
+		}
+		if err != nil {
+			panic(usageError("-geo-rules %s: rule %d: %w", path, i+1, err))
+		}
+	}
+	return rules
+}
+
+// geoRuleOf returns the first rule recognising the variable, or nil if none do.
+func geoRuleOf(variable string) *geoRule {
+	rules := geoRules()
+	for i := range rules {
+		if rules[i].variables.MatchString(variable) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// groups returns the names of the groups of the pattern, which are its columns.
+func (r *geoRule) groups() []string {
+	var names []string
+	for _, name := range r.pattern.SubexpNames() {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// geoColumns returns the identifier columns of the dimensions, following those of
+// the dimensions themselves.
+func geoColumns(dims table.Dimensions) []column {
+	var columns []column
+	for _, d := range dims {
+		if r := geoRuleOf(d.Variable.Name); r != nil {
+			for _, group := range r.groups() {
+				columns = append(columns, column{Name: d.Variable.Name + "_" + group, Variable: d.Variable.Name,
+					Type: "string", Content: "identifier"})
+			}
+		}
+	}
+	return columns
+}
+
+// geoIdentifiers are the identifiers of the categories of the recognised dimensions
+// of a table, extracted once for each category rather than for each row.
+type geoIdentifiers struct {
+	dims   []int                   // indices of the recognised dimensions
+	values map[int][][]value.Value // identifiers of each category of each of dims
+	empty  map[int][]value.Value   // identifiers of a margin, summed over the dimension
+}
+
+// newGeoIdentifiers returns the identifiers of the dimensions, or nil if there is no
+// -geo-rules file. Categories the pattern of their rule doesn't match have empty
+// identifiers, which are counted in a warning.
+func newGeoIdentifiers(dims table.Dimensions) *geoIdentifiers {
+	if *geoRulesPath == "" {
+		return nil
+	}
+	g := &geoIdentifiers{values: map[int][][]value.Value{}, empty: map[int][]value.Value{}}
+	for i, d := range dims {
+		r := geoRuleOf(d.Variable.Name)
+		if r == nil {
+			continue
+		}
+		groups := r.groups()
+		empty := make([]value.Value, len(groups))
+		for j := range empty {
+			empty[j] = value.NewString("")
+		}
+		unmatched := 0
+		values := make([][]value.Value, len(d.Categories))
+		for k, c := range d.Categories {
+			s := c.Label
+			if r.From == "code" {
+				s = c.Code
+			}
+			match := r.pattern.FindStringSubmatch(s)
+			if match == nil {
+				values[k] = empty
+				unmatched++
+				continue
+			}
+			values[k] = make([]value.Value, 0, len(groups))
+			for j, name := range r.pattern.SubexpNames() {
+				if name != "" {
+					values[k] = append(values[k], value.NewString(match[j]))
+				}
+			}
+		}
+		if unmatched > 0 {
+			logf("WARNING", "%d of the %d categories of %s do not match the -geo-rules pattern %s",
+				unmatched, len(d.Categories), d.Variable.Name, r.Pattern)
+		}
+		g.dims = append(g.dims, i)
+		g.values[i], g.empty[i] = values, empty
+	}
+	return g
+}
+
+// appendTo appends the identifiers of the current cell of ti to dst for the first n
+// dimensions, and empty identifiers for the rest, as for ti.AppendMargin.
+func (g *geoIdentifiers) appendTo(dst []value.Value, ti *table.Iterator, n int) []value.Value {
+	if g == nil {
+		return dst
+	}
+	for _, d := range g.dims {
+		if d < n {
+			dst = append(dst, g.values[d][ti.CategoryIndexAtColumn(d)]...)
+		} else {
+			dst = append(dst, g.empty[d]...)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// renamedHeader records the identifier which -snake-case-headers gave a column.
+type renamedHeader struct {
+	Label  string `json:"label"`
+	Column string `json:"column"`
+}
+
+// renamedHeaders are the columns renamed by -snake-case-headers, for the manifest.
+// The mutex guards setting them from the encoders of several -format at once.
+var (
+	renamedHeaders []renamedHeader
+	renamedMu      sync.Mutex
+)
+
+// snakeCaseColumns renames the columns to snake_case identifiers which may be used
+// directly as database column names, recording the renaming in renamedHeaders.
+// Identifiers are made unique by appending _2, _3 and so on.
+func snakeCaseColumns(columns []column) {
+	renamed := make([]renamedHeader, 0, len(columns))
+	used := make(map[string]bool, len(columns))
+	for i, c := range columns {
+		id := snakeCase(c.Name)
+		for n := 2; used[id]; n++ {
+			id = snakeCase(c.Name) + "_" + strconv.Itoa(n)
+		}
+		used[id] = true
+		columns[i].Name = id
+		renamed = append(renamed, renamedHeader{c.Name, id})
+	}
+	renamedMu.Lock()
+	renamedHeaders = renamed
+	renamedMu.Unlock()
+}
+
+// snakeCase converts s to a lower case ASCII identifier, with accents removed and
+// each run of other characters replaced by an underscore. An identifier beginning
+// with a digit is prefixed with an underscore.
+func snakeCase(s string) string {
+	var sb strings.Builder
+	underscore := false
+	for _, r := range norm.NFD.String(s) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// drop the accents separated by decomposition
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if underscore && sb.Len() > 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(unicode.ToLower(r))
+			underscore = false
+		default:
+			underscore = true
+		}
+	}
+	id := sb.String()
+	switch {
+	case id == "":
+		return "column"
+	case id[0] >= '0' && id[0] <= '9':
+		return "_" + id
+	}
+	return id
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// historyEnv is the environment variable giving the history file when there is no -history.
+const historyEnv = "CANTABULAR_HISTORY"
+
+// historyEntry records a query in the history file, one JSON object per line.
+type historyEntry struct {
+	RunID     string    `json:"run_id"`
+	Started   time.Time `json:"started"`
+	Duration  float64   `json:"duration_seconds"`
+	URL       string    `json:"url"`
+	Dataset   string    `json:"dataset"`
+	Variables []string  `json:"variables"`
+	Filters   string    `json:"filters,omitempty"`
+	Output    string    `json:"output,omitempty"`
+	Args      []string  `json:"args"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// historyFile returns the path of the history file, or "" if no history is kept.
+func historyFile() string {
+	if *historyPath != "" {
+		return *historyPath
+	}
+	return os.Getenv(historyEnv)
+}
+
+// recordHistory appends an entry for the query of dataset and vars begun at started
+// to the history file at path. It is deferred, so that failed queries are recorded
+// too, and passes on any panic once recorded. Failing to record is only a warning,
+// as the history is not worth losing an output for.
+func recordHistory(path string, started time.Time, dataset string, vars []string) {
+	err := recover()
+	e := historyEntry{
+		RunID:     runID,
+		Started:   started,
+		Duration:  time.Since(started).Seconds(),
+		URL:       apiURLs.String(),
+		Dataset:   dataset,
+		Variables: vars,
+		Filters:   userFilters.String(),
+		Output:    *outputPath,
+		Args:      redactArgs(os.Args[1:]),
+	}
+	if err != nil {
+		e.Error = fmt.Sprint(err)
+	}
+	if werr := appendHistory(path, e); werr != nil {
+		logf("WARNING", "recording history: %s", werr)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// appendHistory appends e to the history file at path as a single write, so that
+// the lines of concurrent runs are not interleaved.
+func appendHistory(path string, e historyEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// secretFlags are the flags whose values may be secrets, so are not recorded in
+// manifests or the history.
+var secretFlags = map[string]bool{
+	"auth-token": true,
+	"H":          true,
+	"proxy":      true, // the URL may include a password
+}
+
+// redactArgs returns a copy of the command line args without the secretFlags and
+// their values. A re-run obtains the token from the environment instead, and uses
+// any secretFlags given to it, see secretArgs.
+func redactArgs(args []string) []string {
+	redacted := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if args[i] == "--" || !strings.HasPrefix(args[i], "-") {
+			redacted = append(redacted, args[i])
+			continue
+		}
+		if secretFlags[name] {
+			if !hasValue {
+				i++ // the value is the next argument
+			}
+			continue
+		}
+		redacted = append(redacted, args[i])
+	}
+	return redacted
+}
+
+// readHistory returns the entries of the history file at path, oldest first.
+func readHistory(path string) []historyEntry {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = f.Close() }()
+	var entries []historyEntry
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		var e historyEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			// a line cut short by a crash should not hide the rest of the history
+			logf("WARNING", "%s:%d: %s", path, line, err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := s.Err(); err != nil {
+		panic(err)
+	}
+	return entries
+}
+
+// matches returns whether every term occurs in the dataset, variables, filters,
+// output or URL of e, ignoring case.
+func (e historyEntry) matches(terms []string) bool {
+	text := strings.ToLower(strings.Join(append([]string{e.Dataset, e.Filters, e.Output, e.URL}, e.Variables...), "\n"))
+	for _, t := range terms {
+		if !strings.Contains(text, strings.ToLower(t)) {
+			return false
+		}
+	}
+	return true
+}
+
+// historyCommand lists the entries of the history file matching every term in args,
+// numbered so that one may be re-run with -rerun.
+func historyCommand(ctx context.Context, args []string) {
+	path := historyFile()
+	if path == "" {
+		panic(usageError("no history is kept, give -history or set %s", historyEnv))
+	}
+	entries := readHistory(path)
+	if *rerun != 0 {
+		if len(args) != 0 {
+			usage()
+		}
+		if *rerun < 1 || *rerun > len(entries) {
+			panic(usageError("-rerun %d: there are %d entries in %s", *rerun, len(entries), path))
+		}
+		rerunEntry(ctx, path, entries[*rerun-1])
+		return
+	}
+	lw := newListingWriter([]string{"n", "started", "dataset", "variables", "filters", "output", "duration_seconds", "error"})
+	defer lw.close()
+	for i, e := range entries {
+		if !e.matches(args) {
+			continue
+		}
+		lw.write([]string{
+			strconv.Itoa(i + 1),
+			e.Started.Format(time.RFC3339),
+			e.Dataset,
+			strings.Join(e.Variables, " "),
+			e.Filters,
+			e.Output,
+			strconv.FormatFloat(e.Duration, 'f', 3, 64),
+			e.Error,
+		})
+	}
+}
+
+// rerunEntry runs the command line of e again with this program, with any
+// secretFlags given now, recording it in the history file at path, and exits with
+// its exit code.
+func rerunEntry(ctx context.Context, path string, e historyEntry) {
+	logf("INFO", "re-running run=%s: %s", e.RunID, strings.Join(e.Args, " "))
+	runSelf(ctx, append(secretArgs(), e.Args...), historyEnv+"="+path)
+}
+
+// secretArgs returns the arguments giving the secretFlags set on the command line again.
+func secretArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		switch {
+		case !secretFlags[f.Name]:
+		case f.Name == "H":
+			for name, values := range customHeaders {
+				for _, v := range values {
+					args = append(args, "-H="+name+": "+v)
+				}
+			}
+		default:
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// incrementalTableQuery is cantabular.TableQuery with the values delivered incrementally using @stream.
+// It panics if TableQuery no longer has the values field on a line of its own, rather than
+// silently querying without @stream.
+var incrementalTableQuery = func() string {
+	q := strings.Replace(cantabular.TableQuery, "   values\n", "   values @stream(initialCount: 0)\n", 1)
+	if q == cantabular.TableQuery {
+		panic("no values field to @stream in cantabular.TableQuery")
+	}
+	return q
+}()
+
+// incrementalToJSON converts a multipart/mixed GraphQL incremental delivery response, in which
+// the table values are streamed with @stream, into a single GraphQL JSON response as described
+// by writeIncrementalJSON. The result is written to the returned reader as each part arrives,
+// so it can be converted as if the API had sent an ordinary response. Closing the returned
+// reader closes body, and stops the conversion even if the result hasn't all been read.
+func incrementalToJSON(body io.ReadCloser, boundary string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeIncrementalJSON(multipart.NewReader(body, boundary), pw))
+	}()
+	return incrementalReader{pr, body}
+}
+
+// incrementalReader reads the result of incrementalToJSON. It closes the pipe as well as
+// the response when closed, so that the goroutine writing to the pipe isn't left blocked.
+type incrementalReader struct {
+	*io.PipeReader
+	body io.Closer
+}
+
+func (r incrementalReader) Close() error {
+	_ = r.PipeReader.Close()
+	return r.body.Close()
+}
+
+// incrementalPayload is a part of an incremental delivery response. The first part
+// contains the data apart from the streamed values. Each subsequent part contains
+// some of the values in the items of its incremental results.
+type incrementalPayload struct {
+	Data *struct {
+		Dataset *struct {
+			Table *struct {
+				Dimensions json.RawMessage
+				Values     []json.Number
+				Error      *string
+			}
+		}
+	}
+	Errors      json.RawMessage
+	Incremental []struct {
+		Items  []json.Number
+		Errors []struct{ Message string }
+	}
+	HasNext bool
+}
+
+// writeIncrementalJSON writes the JSON of a GraphQL table response to w from the parts of
+// the incremental delivery response read by mr. The errors from the first part are written
+// before the data, and the dimensions before the values, as graphqlJSONToCSV expects.
+func writeIncrementalJSON(mr *multipart.Reader, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var payload incrementalPayload
+	if err := decodePart(mr, &payload); err != nil {
+		return err
+	}
+	bw.WriteString(`{`)
+	if len(payload.Errors) > 0 {
+		fmt.Fprintf(bw, `"errors":%s,`, payload.Errors)
+	}
+	if payload.Data == nil || payload.Data.Dataset == nil || payload.Data.Dataset.Table == nil {
+		// nothing will be streamed, so the remaining parts needn't be read
+		bw.WriteString(`"data":{"dataset":null}}`)
+		return bw.Flush()
+	}
+	t := payload.Data.Dataset.Table
+	errMsg, err := json.Marshal(t.Error)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bw, `"data":{"dataset":{"table":{"dimensions":%s,"error":%s,"values":[`, t.Dimensions, errMsg)
+	n := writeItems(bw, t.Values, 0)
+	for payload.HasNext {
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+		payload = incrementalPayload{}
+		if err := decodePart(mr, &payload); err != nil {
+			return err
+		}
+		for _, inc := range payload.Incremental {
+			if len(inc.Errors) > 0 {
+				return graphQLError(inc.Errors[0].Message)
+			}
+			n = writeItems(bw, inc.Items, n)
+		}
+	}
+	bw.WriteString(`]}}}}`)
+	return bw.Flush()
+}
+
+// decodePart decodes the JSON of the next part of the response into payload.
+func decodePart(mr *multipart.Reader, payload *incrementalPayload) error {
+	part, err := mr.NextPart()
+	if err == io.EOF {
+		return fmt.Errorf("incremental delivery response ended before the last payload")
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = part.Close() }()
+	return json.NewDecoder(part).Decode(payload)
+}
+
+// writeItems writes values as elements of a JSON array of which n have already been
+// written, and returns the number written in total.
+func writeItems(bw *bufio.Writer, values []json.Number, n int) int {
+	for _, v := range values {
+		if n > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString(v.String())
+		n++
+	}
+	return n
+}
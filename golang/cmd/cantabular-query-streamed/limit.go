@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// previewRows is the number of rows -preview writes unless -limit is given.
+const previewRows = 20
+
+// limitReached is set once -limit rows of the table have been written, after which
+// the rest of the values are not decoded.
+var limitReached atomic.Bool
+
+// errLimit is panicked once -limit rows have been written, to stop decoding the
+// response, whose request is abandoned when its body is closed.
+var errLimit = errors.New("-limit rows written")
+
+// atLimit returns whether -limit rows have been written, having written rows,
+// recording it if so.
+func atLimit(rows int) bool {
+	if *limit > 0 && rows >= *limit {
+		limitReached.Store(true)
+		return true
+	}
+	return false
+}
+
+// reportCells reports the number of cells of the table to stderr for -preview, from
+// the counts of the categories of its dimensions, so that a query can be checked
+// before all of it is downloaded.
+func reportCells(dims table.Dimensions) {
+	cells := 1.0 // as a float so that the product of many dimensions cannot overflow
+	counts := make([]string, 0, len(dims))
+	for _, d := range dims {
+		cells *= float64(d.Count)
+		counts = append(counts, fmt.Sprintf("%d %s", d.Count, d.Variable.Name))
+	}
+	logf("INFO", "the table has %.0f cells, of %s, of which the first %d rows are written",
+		cells, strings.Join(counts, " by "), *limit)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/rounding"
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/cantabular"
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// lintMaxCells is the number of cells above which lint reports a table as implausible,
+// as tables so large are usually requested by mistake, and time out or are refused.
+const lintMaxCells = 100000000
+
+// lintCommand checks the query of the manifest in args, with its options as rerun
+// would make it, or of the dataset and variables in args with the options of this
+// command line, without making it. Each problem found is written to stdout and the
+// exit code is exitUsage if there are any, so that mistakes in the queries of
+// scheduled pipelines are caught when they are reviewed rather than when they run.
+// The variables, filter codes and number of cells are checked against the API if
+// it can be reached.
+func lintCommand(ctx context.Context, args []string) {
+	var (
+		dataset  string
+		vars     []string
+		problems []string
+	)
+	switch {
+	case len(args) == 1 && strings.HasSuffix(args[0], ".json"):
+		m := readManifest(args[0])
+		dataset, vars = m.Dataset, m.Variables
+		given := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+		if !given["u"] {
+			if err := apiURLs.Set(m.URL); err != nil {
+				problems = append(problems, fmt.Sprintf("url: %s", err))
+			}
+		}
+		// each is parsed alone so that one which is not an option doesn't hide the
+		// rest, and without the usage the flag package would print for it
+		flag.CommandLine.SetOutput(io.Discard)
+		for _, arg := range manifestArgs(m, given) {
+			if err := flag.CommandLine.Parse([]string{arg}); err != nil {
+				problems = append(problems, fmt.Sprintf("options: %s", err))
+			}
+		}
+		flag.CommandLine.SetOutput(nil)
+	case len(args) >= 2:
+		dataset, vars = args[0], args[1:]
+	default:
+		usage()
+	}
+	problems = append(problems, optionProblems(vars)...)
+	problems = append(problems, variableProblems(vars)...)
+	problems = append(problems, apiProblems(ctx, dataset, vars)...)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(exitUsage)
+	}
+	logf("INFO", "no problems found in the query of %s", dataset)
+}
+
+// optionProblems returns the problems with the options of a query of vars: values
+// which are not recognised and options which cannot be combined.
+func optionProblems(vars []string) []string {
+	var problems []string
+	check := func(bad bool, format string, a ...interface{}) {
+		if bad {
+			problems = append(problems, fmt.Sprintf(format, a...))
+		}
+	}
+	check(*inputPath != "" || *queryFile != "", "-i and -query-file are not queries of a dataset")
+	check(*queryVars != "", "-vars are the variables of a -query-file query")
+	formats := outputFormats()
+	seen := make(map[string]bool, len(formats))
+	for _, f := range formats {
+		check(!sink.Registered(f), "unknown -format %q, expected one of %v", f, sink.Names())
+		check(seen[f], "-format %s is repeated", f)
+		seen[f] = true
+	}
+	check(*format == "sqlite" && *outputPath == "", "-format sqlite requires -o <database>")
+	check(*format == "sqlite" && gzipOutput(), "-format sqlite cannot be gzip compressed")
+	check(len(formats) > 1 && *outputPath == "", "several -format require -o <name> to name the output files")
+	check(len(formats) > 1 && gzipOutput(), "several -format cannot be gzip compressed")
+	check(strings.HasSuffix(*outputPath, ".zip") && *gzipFlag, "-o .zip is already compressed, so cannot be used with -gzip")
+	check(strings.HasSuffix(*outputPath, ".zip") && (*summary || *assoc),
+		"-o .zip bundles a table with its schema, so cannot be used with -summary or -assoc")
+	check(*showTotals && *percent != "", "-totals cannot be combined with -percent")
+	check(*showTotals && *pivot != "", "-totals cannot be combined with -pivot")
+	check(*percent != "" && *pivot != "", "-percent cannot be combined with -pivot")
+	check(*percentSum100 && *percent == "", "-percent-sum-100 requires -percent")
+	if *geoRulesPath != "" {
+		problem := usageProblem(func() { readGeoRules(*geoRulesPath) })
+		check(problem != "", "%s", problem)
+	}
+	if *pivot != "" {
+		problem := usageProblem(func() { pivotLast(vars) })
+		check(problem != "", "%s", problem)
+	}
+	_, ok := csvDialects[*dialectName]
+	check(!ok, "unknown -dialect %q, expected rfc4180, excel-eu or tsv", *dialectName)
+	check(*transport != "http" && *transport != "ws", "unknown -transport %q, expected http or ws", *transport)
+	switch *decodeStrategy {
+	case "buffered", "streamed", "auto":
+	default:
+		check(true, "unknown -decode %q, expected buffered, streamed or auto", *decodeStrategy)
+	}
+	switch *orderCategories {
+	case "code", "label", "source":
+	default:
+		check(true, "unknown -order-categories %q, expected code, label or source", *orderCategories)
+	}
+	if _, err := cantabular.ParseStatuses(*retryOn); err != nil {
+		check(true, "-retry-on: %s", err)
+	}
+	if *roundBase > 0 {
+		if _, err := rounding.New(*roundBase, *roundMethod, *roundSeed); err != nil {
+			check(true, "%s", err)
+		}
+	}
+	if *maxLabelWidth != "" {
+		problem := usageProblem(func() { labelWidths(*maxLabelWidth) })
+		check(problem != "", "%s", problem)
+	}
+	if *percent != "" {
+		dims := make(table.Dimensions, len(vars))
+		for i, v := range vars {
+			dims[i].Variable.Name = v
+		}
+		problem := usageProblem(func() { percentDimension(dims) })
+		check(problem != "", "%s", problem)
+	}
+	return problems
+}
+
+// usageProblem calls f, returning the message if it panics with an error in the
+// command line, so that the checks of options made as a query runs can be reused
+// to check them beforehand.
+func usageProblem(f func()) (problem string) {
+	defer func() {
+		if r := recover(); r != nil {
+			if exitCode(r) != exitUsage {
+				panic(r)
+			}
+			problem = fmt.Sprint(r)
+		}
+	}()
+	f()
+	return ""
+}
+
+// variableProblems returns the problems with the variables of a query: variables
+// requested more than once and filters of variables which are not requested.
+func variableProblems(vars []string) []string {
+	var problems []string
+	requested := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		if requested[v] {
+			problems = append(problems, fmt.Sprintf("%s: variable is requested more than once", v))
+		}
+		requested[v] = true
+	}
+	filtered := make(map[string]bool, len(userFilters))
+	for _, f := range userFilters {
+		if !requested[f.Variable] && !filtered[f.Variable] {
+			problems = append(problems, fmt.Sprintf("%s: -filter of a variable which is not requested", f.Variable))
+		}
+		filtered[f.Variable] = true
+	}
+	return problems
+}
+
+// apiProblems returns the problems with the query of vars which are found from the
+// metadata of the dataset: unknown variables and filter codes, and an implausible
+// number of cells. If the metadata cannot be obtained then a warning is printed and
+// nothing is checked.
+func apiProblems(ctx context.Context, dataset string, vars []string) (problems []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			logf("WARNING", "not checking the variables, filter codes and cells against the API: %s", r)
+			problems = nil
+		}
+	}()
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range vars {
+		names, seen[v] = append(names, v), true
+	}
+	for _, f := range userFilters {
+		if !seen[f.Variable] {
+			names, seen[f.Variable] = append(names, f.Variable), true
+		}
+	}
+	known := make(map[string][]string)
+	for _, vc := range categoryCodes(ctx, dataset, names) {
+		known[vc.Name] = vc.Codes
+	}
+	cells := 1.0 // as a float so that the product of many variables cannot overflow
+	for _, v := range vars {
+		codes, ok := known[v]
+		if !ok {
+			if userFilters.codes(v) == nil { // otherwise reported by filterProblems
+				problems = append(problems, fmt.Sprintf("%s: no such variable", v))
+			}
+			continue
+		}
+		if filtered := userFilters.codes(v); filtered != nil {
+			codes = filtered
+		}
+		cells *= float64(len(codes))
+	}
+	problems = append(problems, filterProblems(userFilters, known)...)
+	if cells > lintMaxCells {
+		problems = append(problems, fmt.Sprintf("the table has %.0f cells, more than the %d which are plausible",
+			cells, lintMaxCells))
+	}
+	return problems
+}
@@ -17,131 +17,666 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/cantabular/examples/cmd/cantabular-query-streamed/jsonstream"
-	"github.com/cantabular/examples/cmd/cantabular-query-streamed/table"
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/ratelimit"
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/rounding"
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/source"
+	"github.com/cantabular/examples/pkg/cantabular"
+	"github.com/cantabular/examples/pkg/jsonstream"
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/table/stats"
+	"github.com/cantabular/examples/pkg/value"
 )
 
-var apiUrl = flag.String("u", "http://localhost:8492/graphql",
-	"Extended API URL")
+var (
+	apiURLs          = newEndpoints("http://localhost:8492/graphql")
+	userFilters      filters
+	customHeaders    cantabular.Headers
+	checkFilterCodes = flag.Bool("check-filters", false,
+		"Before querying, verify that the -filter codes are categories of their variables and suggest corrections")
+	roundRobin = flag.Bool("round-robin", false,
+		"Rotate between the -u URLs for each request rather than preferring the first")
+	maxBandwidth = flag.String("max-bandwidth", "",
+		"Limit download rate of the response, e.g. 10MB/s or 512KiB/s")
+	authToken = flag.String("auth-token", "",
+		"Token to authenticate with as an Authorization: Bearer header (default $"+cantabular.TokenEnv+")")
+	apiKeyHeader = flag.String("api-key-header", "",
+		"Also send the -auth-token in this header, e.g. X-API-Key, for proxies expecting an API key")
+	caCert = flag.String("cacert", "",
+		"Verify the API with the CA certificates in this PEM file rather than the system's")
+	clientCert = flag.String("cert", "",
+		"Authenticate with the client certificate in this PEM file, for mutual TLS, with -key")
+	clientKey = flag.String("key", "",
+		"Private key of the -cert client certificate, in a PEM file")
+	insecure = flag.Bool("insecure", false,
+		"Skip verification of the TLS certificate of the API, e.g. for a test server")
+	proxy = flag.String("proxy", "",
+		"Connect to the API through this http, https or socks5 proxy URL (default $HTTPS_PROXY or\n"+
+			"$HTTP_PROXY, except for hosts in $NO_PROXY)")
+	retries = flag.Int("retries", 0,
+		"Retry requests failing with a connection error or a -retry-on status up to this many times")
+	retryBackoff = flag.Duration("retry-backoff", time.Second,
+		"Delay before the first retry, doubled with jitter for each further retry")
+	retryOn = flag.String("retry-on", "502,503",
+		"Comma-separated HTTP statuses which are retried")
+	splitAfter = flag.Int("split-after", 2,
+		"Split the query into sub-queries after this many gateway timeouts (0 disables)")
+	showStats = flag.Bool("stats", false,
+		"Report connection and transfer timings of each request to stderr")
+	cacheDir = flag.String("cache-dir", "",
+		"Directory in which to cache outputs of repeated identical queries, and the categories of\n"+
+			"variables until the dataset changes")
+	cacheTTL = flag.Duration("cache-ttl", time.Hour,
+		"How long outputs in -cache-dir remain valid")
+	refreshMetadata = flag.Bool("refresh-metadata", false,
+		"Query the categories of variables rather than using those in -cache-dir, and cache them again")
+	format = flag.String("format", "csv",
+		"Output format: csv, parquet with dimensions as string columns and count as int64, xlsx,\n"+
+			"sqlite to add a table to the -o database, or another registered sink. Several separated\n"+
+			"by commas are encoded at once, each to a file named from -o with the format as extension,\n"+
+			"e.g. out.csv, out.parquet")
+	dialectName = flag.String("dialect", "",
+		"Preset of how CSV is written: rfc4180 with CRLF line endings, excel-eu with semicolons,\n"+
+			"decimal commas, CRLF line endings and a UTF-8 byte order mark, as Excel expects where the\n"+
+			"decimal separator is a comma, or tsv for tab-separated values which are never quoted.\n"+
+			"By default commas separate fields, quoted where needed, and lines end with LF")
+	runIDComment = flag.Bool("run-id-comment", false,
+		"Begin CSV output with a \"# run-id: <id>\" line identifying the run, as in the manifest and\n"+
+			"logs, for readers which skip comments, e.g. pandas.read_csv(comment='#'). Only the default\n"+
+			"and tsv -dialect allow it, as the readers of the others expect the header first. The output\n"+
+			"isn't cached with -cache-dir, as its run-id would be stale")
+	outputPath = flag.String("o", "",
+		"Write the output to this file rather than stdout. If it ends in .zip then the query is\n"+
+			"bundled in it as data.<format>, schema.json, manifest.json and SHA256SUMS, and if it\n"+
+			"ends in .gz then the output is gzip compressed")
+	gzipFlag = flag.Bool("gzip", false,
+		"Gzip compress the output, as is done when -o ends in .gz")
+	decimals = flag.Int("decimals", -1,
+		"Write values with this many decimal places, for weighted datasets whose values may be\n"+
+			"fractional, storing counts as floating point in typed formats (-1 writes values as\n"+
+			"received and stores counts as integers)")
+	rowGroupSize = flag.Int("row-group-size", 100000,
+		"Number of rows buffered in each row group of -format parquet")
+	summary = flag.Bool("summary", false,
+		"Write summary statistics of the table instead of CSV")
+	assoc = flag.Bool("assoc", false,
+		"Write chi-square, Cramér's V and expected values of a two-way table instead of CSV")
+	roundBase = flag.Int("round-base", 0,
+		"Round counts in the CSV output to a multiple of this base (0 disables)")
+	roundMethod = flag.String("round-method", "nearest",
+		"How -round-base rounds counts: nearest (deterministic) or random (unbiased, see -round-seed)")
+	roundSeed = flag.Int64("round-seed", 1,
+		"Seed for -round-method random; the same seed reproduces the same output")
+	orderCategories = flag.String("order-categories", "source",
+		"Order of the categories of each dimension: code, label, or source for the order of the API")
+	snakeCaseHeaders = flag.Bool("snake-case-headers", false,
+		"Convert column headers to unique snake_case ASCII identifiers, recording the originals in any -manifest")
+	showProgress = flag.Bool("progress", false,
+		"Report the bytes of the response read, rows written, rows per second and, if the length\n"+
+			"of the response is known, the estimated time remaining to stderr every few seconds")
+	geoRulesPath = flag.String("geo-rules", "",
+		"Append identifier columns for geography variables recognised by the rules in this JSON file,\n"+
+			"extracted from the codes or labels of their categories, e.g. GSS codes from labels such as\n"+
+			"\"E06000001 Hartlepool\" with [{\"variables\": \"^ltla$\", \"from\": \"label\", \"pattern\":\n"+
+			"\"^(?P<gss_code>[EKNSW]\\\\d{8}) (?P<name>.+)$\"}]. Each named group of a pattern is a column\n"+
+			"<variable>_<group>")
+	pivot = flag.String("pivot", "",
+		"Spread the categories of this variable across columns, with the count of each, rather than\n"+
+			"write a row for each cell. It is queried as the last variable, so that only the cells of\n"+
+			"one row are held at a time")
+	limit = flag.Int("limit", 0,
+		"Stop after writing this many rows of the table, abandoning the rest of the response (0 for\n"+
+			"no limit)")
+	preview = flag.Bool("preview", false,
+		"Write the first rows of the table, 20 unless -limit is given, and report its number of cells\n"+
+			"from the counts of its dimensions to stderr, to check a large query before downloading it")
+	skipZeros = flag.Bool("skip-zeros", false,
+		"Omit the rows of cells with a count of zero, which are most of the rows of sparse tables\n"+
+			"such as census crosstabs, so that the output contains only non-zero cells")
+	showTotals = flag.Bool("totals", false,
+		"Add a row after each category of the first dimension with its subtotal over the others,\n"+
+			"and a last row with the grand total, with Total as the category of summed dimensions")
+	parallel = flag.Int("parallel", 4,
+		"Number of metadata queries to make at once, such as for the categories of each variable")
+	percent = flag.String("percent", "",
+		"Add a percent column of each count as a percentage of its total over a dimension: row for the\n"+
+			"last, col for the first, a variable, or total for the grand total. Only row streams without\n"+
+			"holding more than a row of the table")
+	percentSum100 = flag.Bool("percent-sum-100", false,
+		"Round the -percent percentages of each total so that they sum to exactly 100, as published\n"+
+			"tables require, rounding up those with the largest remainders rather than each independently")
+	combinedLabels = flag.String("combined-labels", "",
+		`Format each category from a template in which {code} and {label} are replaced, e.g. "{code} - {label}"`)
+	maxLabelWidth = flag.String("max-label-width", "",
+		"Truncate category labels to this many characters, with a warning listing those affected,\n"+
+			`either for every variable, per variable, or both, e.g. "40" or "40,city=20"`)
+	codes = flag.Bool("codes", false,
+		"Add a <variable>_code column of category codes after the label column of each variable")
+	rowNumbers = flag.Bool("row-numbers", false,
+		"Add a first column numbering the rows from 1")
+	schemaPath = flag.String("schema", "",
+		"Write a JSON description of the columns of the CSV output to this file")
+	manifestPath = flag.String("manifest", "",
+		"Write a JSON manifest describing the run and query to this file")
+	sinceManifest = flag.String("since-manifest", "",
+		"Exit successfully without querying if the -manifest of a previous run of the query\n"+
+			"at this path records the current digest of the dataset")
+	ruleReportPath = flag.String("rule-report", "",
+		"Write a JSON report of the rule variable and disclosure control status of the table to\n"+
+			"this file before querying it, so that a blocked table is described rather than just an error")
+	historyPath = flag.String("history", "",
+		"Append a JSON line recording each query, its URL, duration and output to this file\n"+
+			"(default $"+historyEnv+"), to be searched and re-run with the history subcommand")
+	rerun = flag.Int("rerun", 0,
+		"With history, run the query of this numbered entry again")
+	verifyDigest = flag.Bool("verify-digest", false,
+		"With rerun, fail if the dataset has changed since the manifest was written")
+	saveResponsePath = flag.String("save-response", "",
+		"Save the GraphQL response to this file as it is converted, gzip compressed if it ends in .gz")
+	replayDir = flag.String("replay", "",
+		"Instead of querying, convert the responses saved in this directory and compare with recorded outputs")
+	replayUpdate = flag.Bool("replay-update", false,
+		"Record the outputs of -replay rather than comparing them")
+	flushEvery = flag.Int("flush-every", 0,
+		"Flush the CSV output after every this many rows so readers of a pipe see them promptly (0 flushes only when the buffer is full)")
+	incremental = flag.Bool("incremental", false,
+		"Ask the API to stream the values using GraphQL incremental delivery (@stream)")
+	transport = flag.String("transport", "http",
+		"How to send queries: http, or ws to use the graphql-ws protocol over a WebSocket")
+	decodeStrategy = flag.String("decode", "auto",
+		"How to decode the response: buffered, streamed, or auto to buffer only small tables")
+	sourceSpec = flag.String("source", "http",
+		"Where to obtain tables: http to query the extended API, file:<path> to convert a saved\n"+
+			"response whatever the query, generate[:<categories>] to make up a table with that many\n"+
+			"categories (default 10) for each variable, or another registered source")
+	inputPath = flag.String("i", "",
+		"Instead of querying, convert the GraphQL response saved in this file (- for stdin), e.g. with -save-response")
+	queryFile = flag.String("query-file", "",
+		"Instead of the usual query, send the GraphQL query in this file and convert the first\n"+
+			"table object in the response, which must select the same fields of it as the usual query")
+	queryVars = flag.String("vars", "",
+		"A JSON object of the variables of the -query-file query")
+	benchDecodePath = flag.String("bench-decode", "",
+		"Instead of querying, benchmark buffered and streamed conversion of the response saved in this file")
+)
 
 func init() {
-	const usage = `Usage: %s <dataset-name> <var> [<var> ...]
+	flag.Var(apiURLs, "u",
+		"Extended API URL. Repeat or separate with commas to fail over between replicas")
+	flag.Var(&userFilters, "f",
+		"Restrict a variable to categories, as <var>=<code>,<code>... May be repeated")
+	flag.Var(&userFilters, "filter", "Same as -f")
+	flag.Var(&customHeaders, "H",
+		"Send a header with each request, as \"<name>: <value>\", e.g. for an API gateway. May be repeated")
+
+	const usage = `Usage: %[1]s [options] [query] <dataset-name> <var> [<var> ...]
+       %[1]s [options] [query] -i <saved-response>
+       %[1]s [options] [query] -query-file <query.graphql> [-vars <vars.json>]
+       %[1]s [options] [query] -replay <dir>
+       %[1]s [options] [query] -bench-decode <saved-response>
+       %[1]s [options] metadata <dataset-name>
+       %[1]s [options] codebook <dataset-name>
+       %[1]s [options] datasets
+       %[1]s [options] history [-rerun <n>] [<term> ...]
+       %[1]s [options] rerun [-verify-digest] <manifest.json>
+       %[1]s [options] lint <manifest.json> | <dataset-name> <var> [<var> ...]
 
-Writes table output to stdout as CSV.
-Exit code is one on error and errors are reported to stderr.
+query writes table output to stdout (or -o) as CSV, Parquet or Excel, or adds it
+to an SQLite database, and is the default. metadata writes the name, label,
+description and digest of a dataset as JSON, codebook the categories of every
+variable of a dataset, and datasets the datasets which may be queried, as CSV or
+JSON with -format json. history lists the queries recorded with -history which
+mention every term, numbered so that one can be re-run. rerun makes the query of a
+-manifest again, with its URL and options unless they are given. lint checks the
+query of a -manifest, or of a dataset and variables with the options given, without
+making it: for options which are unknown or cannot be combined, duplicate variables,
+filters of variables not requested and, if the API can be reached, unknown variables
+and codes and implausibly many cells. It lists any problems and exits with 3. Options
+may also follow the subcommand.
+Errors are reported to stderr. The exit code is:
+  1  on any other error
+  2  if a filter removed every category of a variable, so that the table has no cells
+  3  if the command line is invalid
+  4  if the API could not be reached or did not respond with 200 OK
+  5  if the API reported GraphQL errors
+  6  if the table was blocked by disclosure control
+  7  if the output could not be written
 
 Options:
 `
+	// parseFlags, rather than the flag package, exits on invalid flags, see exitUsage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
 	}
 }
 
+// emptyTable records that a table with no cells was written, so that the exit code
+// is exitEmpty rather than zero, as the filters were probably not intended.
+var emptyTable bool
+
+// client is used for all requests to the API, see newClient.
+var client *cantabular.Client
+
 // This example demonstrates how tabulated data returned via a GraphQL request
 // may be processed as it is received without holding the whole response in memory.
 // This is known as "streaming". See usage above or run program for help.
 func main() {
-	if flag.Parse(); len(flag.Args()) < 2 {
-		flag.Usage()
-		os.Exit(1)
+	parseFlags(os.Args[1:])
+	name, args := "query", flag.Args()
+	if len(args) > 0 && subcommands[args[0]] != nil {
+		name, args = args[0], args[1:]
 	}
+	// options may also follow the subcommand
+	parseFlags(args)
+	args = flag.Args()
+	if name == "query" {
+		if *benchDecodePath != "" {
+			if len(args) != 0 {
+				usage()
+			}
+			benchDecode(*benchDecodePath)
+			return
+		}
+		if *replayDir != "" {
+			if len(args) != 0 {
+				usage()
+			}
+			if replay(*replayDir, *replayUpdate) > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	// cancelling the context on interrupt aborts any request in progress, which
+	// stops the decoder at its next read of the response
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	defer func() {
 		if err := recover(); err != nil {
-			_, _ = fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
-			os.Exit(1)
+			if ctx.Err() != nil {
+				logf("ERROR", "interrupted: %s", err)
+			} else {
+				logf("ERROR", "%s", err)
+			}
+			os.Exit(exitCode(err))
 		}
 	}()
-	responseBody := makeRequest(flag.Arg(0), flag.Args()[1:])
+	transportOptions := cantabular.TransportOptions{
+		TLS:   cantabular.TLSOptions{CAFile: *caCert, CertFile: *clientCert, KeyFile: *clientKey, Insecure: *insecure},
+		Proxy: *proxy,
+	}
+	if *insecure {
+		logf("WARNING", "not verifying the TLS certificate of the API, as -insecure is given")
+	}
+	var rt http.RoundTripper
+	switch *transport {
+	case "http":
+		t, err := transportOptions.Transport()
+		if err != nil {
+			panic(usageError("%w", err))
+		}
+		rt = t
+	case "ws":
+		t, err := newWSTransport(transportOptions)
+		if err != nil {
+			panic(usageError("%w", err))
+		}
+		rt = t
+	default:
+		panic(usageError("unknown -transport %q, expected http or ws", *transport))
+	}
+	dialect, ok := csvDialects[*dialectName]
+	if !ok {
+		panic(usageError("unknown -dialect %q, expected rfc4180, excel-eu or tsv", *dialectName))
+	}
+	if *runIDComment && !dialect.comments {
+		panic(usageError("-run-id-comment cannot be used with -dialect %s, whose readers don't skip comments", *dialectName))
+	}
+	csvStyle = dialect
+	if *authToken == "" {
+		*authToken = os.Getenv(cantabular.TokenEnv)
+	}
+	if *retries > 0 {
+		retryStatuses, err := cantabular.ParseStatuses(*retryOn)
+		if err != nil {
+			panic(usageError("-retry-on: %s", err))
+		}
+		// retries happen before the response is returned, so never after output is written
+		rt = &cantabular.RetryTransport{
+			Base: rt,
+			Policy: cantabular.RetryPolicy{
+				MaxAttempts: *retries + 1,
+				Backoff:     *retryBackoff,
+				RetryOn:     retryStatuses,
+				OnRetry: func(attempt int, delay time.Duration, reason string) {
+					logf("WARNING", "%s, making attempt %d in %s", reason, attempt, delay.Round(time.Millisecond))
+				},
+			},
+		}
+	}
+	client = newClient(rt)
+	subcommands[name](ctx, args)
+	if emptyTable {
+		os.Exit(exitEmpty)
+	}
+}
+
+// queryCommand writes the table of the dataset and variables in args, or converts the
+// -i response or that of the -query-file query.
+func queryCommand(ctx context.Context, args []string) {
+	instead := *inputPath != "" || *queryFile != ""
+	if instead && len(args) != 0 || !instead && len(args) < 2 {
+		usage()
+	}
+	if *queryVars != "" && *queryFile == "" {
+		panic(usageError("-vars are the variables of a -query-file query"))
+	}
+	if *percentSum100 && *percent == "" {
+		panic(usageError("-percent-sum-100 requires -percent"))
+	}
+	bundled := strings.HasSuffix(*outputPath, ".zip")
+	if bundled && *gzipFlag {
+		panic(usageError("-o .zip is already compressed, so cannot be used with -gzip"))
+	}
+	if bundled && (*summary || *assoc) {
+		// statistics have no schema.json to bundle with them
+		panic(usageError("-o .zip bundles a table with its schema, so cannot be used with -summary or -assoc"))
+	}
+	if *inputPath != "" {
+		if bundled {
+			panic(usageError("-o .zip bundles the outputs of a query, not of -i"))
+		}
+		convertSaved(*inputPath)
+		return
+	}
+	if *queryFile != "" {
+		if bundled {
+			panic(usageError("-o .zip bundles the outputs of a query of a dataset, not of -query-file"))
+		}
+		runPassthrough(ctx, *queryFile, *queryVars)
+		return
+	}
+	dataset, vars := args[0], args[1:]
+	if *preview && *limit == 0 {
+		*limit = previewRows
+	}
+	started := time.Now()
+	if path := historyFile(); path != "" {
+		defer recordHistory(path, started, dataset, vars)
+	}
+	var digest string
+	if *manifestPath != "" || *sinceManifest != "" || bundled {
+		// obtained before the query, so that a change during it is not missed next time
+		var err error
+		if digest, err = datasetDigest(ctx, dataset); err != nil {
+			logf("WARNING", "%s", err)
+		}
+	}
+	if *sinceManifest != "" && digest != "" && unchangedSince(*sinceManifest, dataset, digest, vars) {
+		return
+	}
+	if *ruleReportPath != "" {
+		writeRuleReport(ctx, *ruleReportPath, dataset, vars)
+	}
+	var b *bundle
+	if bundled {
+		b = newBundle()
+		defer b.remove()
+	}
+	runQuery(ctx, dataset, vars)
+	if b != nil {
+		b.finish(started, dataset, digest, vars)
+	}
+	if *manifestPath != "" {
+		writeManifest(*manifestPath, started, dataset, digest, vars)
+	}
+}
+
+// runQuery writes the table for the query to stdout or -o, from the cache if possible.
+func runQuery(ctx context.Context, dataset string, vars []string) {
+	vars = pivotLast(vars)
+	out, closeOut := openOutput()
+	defer closeOut()
+	var entry *cacheEntry
+	if useCache() {
+		if entry = lookupCache(ctx, dataset, vars); entry != nil && entry.fresh() {
+			entry.copyTo(out)
+			return
+		}
+	}
+	if *checkFilterCodes && len(userFilters) > 0 {
+		checkFilters(ctx, dataset, userFilters)
+	}
+	src, err := source.New(*sourceSpec)
+	if err != nil {
+		panic(usageError("-source: %s", err))
+	}
+	convert := chooseConverter(ctx, dataset, vars)
+	responseBody, err := src.Open(ctx, source.Query{Dataset: dataset, Variables: vars, Filters: userFilters})
+	if err != nil {
+		panic(err)
+	}
 	defer func() { _ = responseBody.Close() }()
-	graphqlJSONToCSV(responseBody, os.Stdout)
+	r := io.Reader(responseBody)
+	if *saveResponsePath != "" {
+		var closeSaved func()
+		r, closeSaved = saveResponse(ctx, r, *saveResponsePath)
+		defer closeSaved()
+	}
+	w := out
+	if entry != nil {
+		w = io.MultiWriter(w, entry.create())
+		defer entry.discard()
+	}
+	convert(r, w)
+	if limitReached.Load() {
+		logf("INFO", "stopped after the first %d rows of the table, as -limit is given", *limit)
+	}
+	if entry != nil {
+		entry.commit()
+	}
+}
+
+// openOutput returns the writer for the output, stdout unless -o is given, and a
+// function to close it which panics on error.
+func openOutput() (io.Writer, func()) {
+	switch {
+	case *format == "sqlite":
+		// the table is added to the -o database rather than written out, see sqliteSink
+		if *outputPath == "" {
+			panic(usageError("-format sqlite requires -o <database>"))
+		}
+		if gzipOutput() {
+			panic(usageError("-format sqlite cannot be gzip compressed"))
+		}
+		return io.Discard, func() {}
+	case len(outputFormats()) > 1:
+		// each format is written to its own file, see writeFormats
+		if *outputPath == "" {
+			panic(usageError("several -format require -o <name> to name the output files"))
+		}
+		if gzipOutput() {
+			panic(usageError("several -format cannot be gzip compressed"))
+		}
+		return io.Discard, func() {}
+	case *outputPath != "":
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			panic(outputError(err))
+		}
+		return compressOutput(f, func() {
+			if err := f.Close(); err != nil {
+				panic(outputError(err))
+			}
+		})
+	}
+	return compressOutput(os.Stdout, func() {})
+}
+
+func init() {
+	source.Register("http", func(string) (source.Source, error) { return source.Func(httpSource), nil })
+}
+
+// httpSource is the source of tables queried from the extended API, see makeRequest.
+// It panics on error, as the rest of the command does. The filters of q are those of -f.
+func httpSource(ctx context.Context, q source.Query) (io.ReadCloser, error) {
+	return makeRequest(ctx, q.Dataset, q.Variables), nil
 }
 
 // makeRequest constructs the GraphQL query and obtains the response. It panics on error.
-func makeRequest(dataset string, vars []string) io.ReadCloser {
-	const graphQLQuery = `
-query($dataset: String!, $variables: [String!]!, $filters: [Filter!]) {
- dataset(name: $dataset) {
-  table(variables: $variables, filters: $filters) {
-   dimensions {
-    count
-    variable { name label }
-    categories { code label } }
-   values
-   error
-  }
- }
-}`
-	var b bytes.Buffer
-	enc := json.NewEncoder(&b)
-	if err := enc.Encode(map[string]interface{}{
-		"query": graphQLQuery,
-		"variables": map[string]interface{}{
+// If the query repeatedly times out at the gateway then it is split into sub-queries, see splitQuery.
+func makeRequest(ctx context.Context, dataset string, vars []string) io.ReadCloser {
+	if body := queryTable(ctx, dataset, vars, userFilters); body != nil {
+		return body
+	}
+	return splitQuery(ctx, dataset, vars)
+}
+
+// queryTable requests a table restricted by filters and returns the response body. If the gateway times out
+// then the request is retried, and nil is returned once it has timed out -split-after times.
+// It panics on any other error.
+func queryTable(ctx context.Context, dataset string, vars []string, filters []filter) io.ReadCloser {
+	for attempt := 1; ; attempt++ {
+		query, header := cantabular.TableQuery, streamedHeader()
+		if *incremental {
+			query = incrementalTableQuery
+			header.Set("Accept", "multipart/mixed; deferSpec=20220824, application/json")
+		}
+		resp, err := client.Post(withRequestHeader(ctx, header), query, map[string]interface{}{
 			"dataset":   dataset,
 			"variables": vars,
-		},
-	}); err != nil {
-		panic(fmt.Sprintf("Error encoding JSON request body: %s", err))
+			"filters":   filters,
+		})
+		var statusErr *cantabular.StatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGatewayTimeout && *splitAfter > 0 {
+			if attempt >= *splitAfter {
+				return nil
+			}
+			continue
+		}
+		if err != nil {
+			panic(apiError(err))
+		}
+		if *showProgress {
+			resp.Body = newProgressBody(resp.Body, resp.ContentLength)
+		}
+		var r io.Reader = resp.Body
+		if *maxBandwidth != "" {
+			bytesPerSec, err := ratelimit.ParseBandwidth(*maxBandwidth)
+			if err != nil {
+				panic(usageError("%w", err))
+			}
+			r = ratelimit.NewReader(r, bytesPerSec)
+		}
+		var body io.ReadCloser = struct {
+			io.Reader
+			io.Closer
+		}{gunzipped(r, resp.Header), resp.Body}
+		if mediaType, params, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "multipart/mixed" {
+			body = incrementalToJSON(body, params["boundary"])
+		}
+		return body
 	}
+}
 
-	resp, err := http.Post(*apiUrl, "application/json", &b)
+// postQuery posts a GraphQL query and its variables to the API and returns the
+// response, whose body is requested compressed as it is streamed, see gunzipped.
+// It panics on error, including a status other than 200 OK.
+func postQuery(ctx context.Context, query string, variables map[string]interface{}) *http.Response {
+	resp, err := client.Post(withRequestHeader(ctx, streamedHeader()), query, variables)
 	if err != nil {
-		panic(err)
+		panic(apiError(err))
 	}
-	if resp.StatusCode != http.StatusOK {
-		panic(resp.Status)
+	return resp
+}
+
+// queryData posts a GraphQL query and its variables to the API and decodes the data
+// part of the response into data, which should be a pointer. It panics on error,
+// including any GraphQL errors.
+func queryData(ctx context.Context, query string, variables map[string]interface{}, data interface{}) {
+	if err := client.Do(ctx, query, variables, data); err != nil {
+		panic(apiError(err))
 	}
-	return resp.Body
 }
 
 // graphqlJSONToCSV converts a JSON response in r to CSV on w and panics on error
 func graphqlJSONToCSV(r io.Reader, w io.Writer) {
+	defer func() {
+		// the rest of the response is not read once -limit rows are written
+		if r := recover(); r != nil && r != errLimit {
+			panic(r)
+		}
+	}()
 	dec := jsonstream.New(r)
 	if !dec.StartObjectComposite() {
 		panic("No JSON object found in response")
 	}
+	noDataset := false
 	for dec.More() {
 		switch field := dec.DecodeName(); field {
 		case "data":
 			if dec.StartObjectComposite() {
-				decodeDataFields(dec, w)
+				noDataset = !decodeDataFields(dec, w)
 				dec.EndComposite()
 			}
 		case "errors":
 			decodeErrorsPanicIfAny(dec)
+		default:
+			dec.SkipValue() // such as extensions
 		}
 	}
 	dec.EndComposite()
+	// the dataset is null if the query failed, which the errors following it explain
+	if noDataset {
+		panic(`dataset object expected but "null" found`)
+	}
 }
 
-// decodeDataFields decodes the fields of the data part of the GraphQL response, writing CSV to w
-func decodeDataFields(dec jsonstream.Decoder, w io.Writer) {
-	mustMatchName := func(name string) {
-		if gotName := dec.DecodeName(); gotName != name {
-			panic(fmt.Sprintf("Expected %q but got %q", name, gotName))
+// decodeDataFields decodes the fields of the data part of the GraphQL response, writing CSV to w.
+// It returns false if the dataset is null. Fields other than the dataset and its table,
+// such as those of queries which request more than the table, are skipped.
+func decodeDataFields(dec jsonstream.Decoder, w io.Writer) bool {
+	found, null := false, false
+	for dec.More() {
+		if dec.DecodeName() != "dataset" {
+			dec.SkipValue()
+			continue
+		}
+		if found = true; !dec.StartObjectComposite() {
+			null = true
+			continue
+		}
+		for dec.More() {
+			if dec.DecodeName() != "table" {
+				dec.SkipValue()
+			} else if dec.StartObjectComposite() {
+				decodeTableFields(dec, w)
+				dec.EndComposite()
+			}
 		}
-	}
-	mustMatchName("dataset")
-	if !dec.StartObjectComposite() {
-		panic(`dataset object expected but "null" found`)
-	}
-	mustMatchName("table")
-	if dec.StartObjectComposite() {
-		decodeTableFields(dec, w)
 		dec.EndComposite()
 	}
-	dec.EndComposite()
+	if !found {
+		panic(`Expected "dataset" in data`)
+	}
+	return !null
 }
 
 // decodeErrorsPanicIfAny decodes the errors part of the GraphQL response and
@@ -159,7 +694,7 @@ func decodeErrorsPanicIfAny(dec jsonstream.Decoder) {
 		sb.WriteString(err.Message)
 	}
 	if sb.Len() > 0 {
-		panic(sb.String())
+		panic(graphQLError(sb.String()))
 	}
 }
 
@@ -175,43 +710,267 @@ func decodeTableFields(dec jsonstream.Decoder, w io.Writer) {
 			}
 		case "error":
 			if errMsg := dec.DecodeString(); errMsg != nil {
-				panic(fmt.Sprintf("Table blocked: %s", *errMsg))
+				panic(blockedError(*errMsg))
 			}
 		case "values":
-			if dims == nil {
-				panic("values received before dimensions")
-			}
+			// values are null if the table is blocked, when the error follows
 			if dec.StartArrayComposite() {
-				decodeValues(dec, dims, w)
+				if dims == nil {
+					panic("values received before dimensions")
+				}
+				writeTable(dec, dims, w)
+				if limitReached.Load() {
+					panic(errLimit)
+				}
 				dec.EndComposite()
 			}
+		default:
+			dec.SkipValue() // such as rules, of queries which request them
 		}
 	}
 }
 
-// decodeValues decodes the values of the cells in the table, writing CSV to w.
-func decodeValues(dec jsonstream.Decoder, dims table.Dimensions, w io.Writer) {
-	cw := csv.NewWriter(w)
-	// csv.Writer errors are sticky, so we only need to check when flushing at the end
-	defer func() {
-		cw.Flush()
-		if err := cw.Error(); err != nil {
-			panic(err)
+// cellValues is a source of the values of the cells of a table in row-major order,
+// such as a jsonstream.Decoder positioned within the values array.
+type cellValues interface {
+	More() bool
+	DecodeValue() value.Value
+}
+
+// writeTable writes the table to w in the -format, or as statistics if requested.
+func writeTable(values cellValues, dims table.Dimensions, w io.Writer) {
+	if empty := dims.Empty(); len(empty) > 0 {
+		logf("WARNING", "the table has no cells as no categories of %s remain after filtering",
+			strings.Join(empty, ", "))
+		emptyTable = true
+	}
+	if err := dims.Validate(); err != nil {
+		// the output is as the API returned it, but anything matching categories by code is not
+		for _, msg := range strings.Split(err.Error(), "\n") {
+			logf("WARNING", "%s", msg)
 		}
-	}()
-	// construct the CSV header and write it
-	columns := make([]string, 0, len(dims)+1)
+	}
+	if *preview {
+		reportCells(dims)
+	}
+	values, dims = orderCategoryValues(values, dims)
+	dims = truncateLabels(dims)
+	switch formats := outputFormats(); {
+	case *summary || *assoc:
+		decodeStatistics(values, dims, w)
+	case len(formats) > 1:
+		writeFormats(values, dims, formats)
+	default:
+		encode(*format, values, dims, w, *outputPath)
+	}
+}
+
+// encode writes the table to w with the sink registered for the format, or for sqlite
+// adds it to the database at path.
+func encode(format string, values cellValues, dims table.Dimensions, w io.Writer, path string) {
+	out, err := sink.New(format, w)
+	if err != nil {
+		panic(usageError("-format: %s", err))
+	}
+	var percentages *percentValues
+	if *percent != "" {
+		percentages = newPercentValues(values, dims)
+		values = percentages
+	}
+	if *pivot != "" {
+		checkPivot(dims)
+	}
+	var totals *table.Totals
+	if *showTotals {
+		if percentages != nil {
+			panic(usageError("-totals cannot be combined with -percent"))
+		}
+		totals = &table.Totals{}
+	}
+	header := csvColumns(dims)
+	if *schemaPath != "" {
+		writeSchema(*schemaPath, header)
+	}
+	variables := make([]string, 0, len(dims))
 	for _, d := range dims {
-		columns = append(columns, d.Variable.Label)
+		variables = append(variables, d.Variable.Name)
 	}
-	_ = cw.Write(append(columns, "count"))
-	// write the data rows
-	for ti := dims.NewIterator(); dec.More(); {
+	if err := out.Open(sink.Metadata{Columns: header, Variables: variables, Path: path}); err != nil {
+		panic(outputError(err))
+	}
+	flusher, _ := out.(sink.Flusher)
+	cellValue, category := valueFunc(values), categoryFunc()
+	columns := make([]value.Value, 0, len(header))
+	// writeRow writes the row, with its number if -row-numbers, its categories
+	// appended to the columns by categories, and its counts
+	writeRow := func(row int, categories func(columns []value.Value) []value.Value, counts ...value.Value) {
 		columns = columns[:0] // save allocations
-		for i := range dims {
-			columns = append(columns, ti.CategoryAtColumn(i).Label)
+		if *rowNumbers {
+			columns = append(columns, value.NewInt(int64(row)))
+		}
+		columns = append(categories(columns), counts...)
+		if percentages != nil {
+			columns = append(columns, percentages.percentage())
+		}
+		if err := out.WriteRow(columns); err != nil {
+			panic(outputError(err))
+		}
+		rowsWritten.Add(1)
+		if flusher != nil && *flushEvery > 0 && row%*flushEvery == 0 {
+			if err := flusher.Flush(); err != nil {
+				panic(outputError(err))
+			}
+		}
+	}
+	row, ti := 1, dims.NewIterator()
+	if *pivot != "" {
+		// the counts of a row are those of the categories of the last dimension
+		ids := newGeoIdentifiers(dims[:len(dims)-1])
+		rowCategories := func(columns []value.Value) []value.Value {
+			columns = ti.AppendLeading(columns, category, *codes, len(dims)-1)
+			return ids.appendTo(columns, ti, len(dims)-1)
+		}
+		counts := make([]value.Value, 0, dims[len(dims)-1].Count)
+		for values.More() && !atLimit(row-1) {
+			if counts = append(counts, cellValue()); ti.LastInCategory(len(dims) - 2) {
+				if !*skipZeros || !allZero(counts) {
+					writeRow(row, rowCategories, counts...)
+					row++
+				}
+				counts = counts[:0]
+			}
+			ti.Next()
+		}
+		if err := out.Close(); err != nil {
+			panic(outputError(err))
+		}
+		return
+	}
+	ids := newGeoIdentifiers(dims)
+	cellCategories := func(columns []value.Value) []value.Value {
+		return ids.appendTo(ti.AppendCategories(columns, category, *codes), ti, len(dims))
+	}
+	cells, written := 0, 0
+	for ; values.More() && !atLimit(written); cells++ {
+		v := cellValue()
+		if f, ok := v.Number(); !*skipZeros || !ok || f != 0 {
+			writeRow(row, cellCategories, v)
+			row++
+			written++
+		}
+		if totals != nil {
+			totals.Add(v)
+			if len(dims) > 1 && ti.LastInCategory(0) {
+				writeRow(row, func(columns []value.Value) []value.Value {
+					return ids.appendTo(ti.AppendMargin(columns, category, *codes, 1, "Total"), ti, 1)
+				}, value.NewFloat(totals.Subtotal(), *decimals))
+				row++
+			}
 		}
-		_ = cw.Write(append(columns, dec.DecodeNumber().String()))
 		ti.Next()
 	}
+	// the grand total is of every cell, so is not written if -limit stopped before some
+	if totals != nil && cells > 0 && !limitReached.Load() {
+		writeRow(row, func(columns []value.Value) []value.Value {
+			return ids.appendTo(ti.AppendMargin(columns, category, *codes, 0, "Total"), ti, 0)
+		}, value.NewFloat(totals.Total(), *decimals))
+	}
+	if err := out.Close(); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// valueFunc returns a function which decodes the next value from dec, rounded if -round-base
+// is set and formatted with -decimals places.
+func valueFunc(dec cellValues) func() value.Value {
+	if *roundBase <= 0 && *decimals < 0 {
+		return dec.DecodeValue
+	}
+	round := func(v float64) float64 { return v }
+	if *roundBase > 0 {
+		rounder, err := rounding.New(*roundBase, *roundMethod, *roundSeed)
+		if err != nil {
+			panic(usageError("%w", err))
+		}
+		round = rounder.Round
+	}
+	return func() value.Value {
+		v := dec.DecodeValue()
+		if f, ok := v.Number(); ok {
+			return value.NewFloat(round(f), *decimals)
+		}
+		return v // such as a flag, which has no number to round
+	}
+}
+
+// combinedLabelField matches the placeholders of the -combined-labels template.
+var combinedLabelField = regexp.MustCompile(`\{(code|label)\}`)
+
+// categoryFunc returns a function which formats a category for output: its label,
+// or as -combined-labels if set. The template is split into its text and placeholders
+// once, so that each category only joins them.
+func categoryFunc() func(c table.Category) string {
+	if *combinedLabels == "" {
+		return func(c table.Category) string { return c.Label }
+	}
+	tmpl := *combinedLabels
+	var texts, fields []string // the text before each field, then after the last
+	last := 0
+	for _, m := range combinedLabelField.FindAllStringSubmatchIndex(tmpl, -1) {
+		texts = append(texts, tmpl[last:m[0]])
+		fields = append(fields, tmpl[m[2]:m[3]])
+		last = m[1]
+	}
+	texts = append(texts, tmpl[last:])
+	return func(c table.Category) string {
+		var b strings.Builder
+		for i, field := range fields {
+			b.WriteString(texts[i])
+			if field == "code" {
+				b.WriteString(c.Code)
+			} else {
+				b.WriteString(c.Label)
+			}
+		}
+		b.WriteString(texts[len(fields)])
+		return b.String()
+	}
+}
+
+// decodeStatistics decodes the values of the cells in the table, writing the
+// statistics requested by -summary and -assoc to w.
+func decodeStatistics(dec cellValues, dims table.Dimensions, w io.Writer) {
+	var s *stats.Summary
+	var a *stats.Association
+	if *summary {
+		s = stats.NewSummary(dims)
+	}
+	if *assoc {
+		var err error
+		if a, err = stats.NewAssociation(dims); err != nil {
+			panic(usageError("%w", err))
+		}
+	}
+	for dec.More() {
+		v, _ := dec.DecodeValue().Number() // a cell without a number counts as zero
+		if s != nil {
+			s.Add(v)
+		}
+		if a != nil {
+			a.Add(v)
+		}
+	}
+	if s != nil {
+		if _, err := s.WriteTo(w); err != nil {
+			panic(outputError(err))
+		}
+	}
+	if a != nil {
+		if s != nil {
+			_, _ = fmt.Fprintln(w)
+		}
+		if _, err := a.WriteTo(w); err != nil {
+			panic(outputError(err))
+		}
+	}
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runID identifies this invocation. It is included in the manifest, in every
+// message logged to stderr, in the X-Request-ID header of each request and, with
+// -run-id-comment, in a comment at the start of CSV output, so that an output can
+// be tied back to the exact run which produced it.
+var runID = newRunID()
+
+// newRunID returns a random (version 4) UUID.
+func newRunID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// logf writes a message to stderr prefixed with its level and the run ID.
+func logf(level, format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(os.Stderr, "%s: run=%s %s\n", level, runID, fmt.Sprintf(format, args...))
+}
+
+// manifest describes a run and the query it made.
+type manifest struct {
+	RunID     string            `json:"run_id"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	URL       string            `json:"url"`
+	Dataset   string            `json:"dataset"`
+	Digest    string            `json:"digest,omitempty"`
+	Variables []string          `json:"variables"`
+	Options   map[string]string `json:"options,omitempty"`
+	Headers   []renamedHeader   `json:"headers,omitempty"`
+}
+
+// writeManifest writes the manifest of a successful run to path.
+func writeManifest(path string, started time.Time, dataset, digest string, vars []string) {
+	m := manifest{
+		RunID:     runID,
+		Started:   started,
+		Finished:  time.Now(),
+		URL:       apiURLs.String(),
+		Dataset:   dataset,
+		Digest:    digest,
+		Variables: vars,
+		Options:   manifestOptions(),
+		Headers:   renamedHeaders,
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		panic(outputError(err))
+	}
+}
+
+// manifestOptions returns the options recorded in a manifest: the flags which were set.
+func manifestOptions() map[string]string {
+	options := map[string]string{}
+	flag.Visit(func(f *flag.Flag) {
+		// the token, -H headers and -proxy may be secrets, such as cookies, so are never recorded
+		if f.Name != "u" && f.Name != "manifest" && f.Name != "since-manifest" && !secretFlags[f.Name] {
+			options[f.Name] = f.Value.String()
+		}
+	})
+	return options
+}
+
+// unchangedSince returns whether the manifest at path records a run of the same query,
+// with the same options affecting the output, on the dataset when it had the same
+// digest, so that its output is still current. A missing manifest, as on the first of
+// a series of runs, is never unchanged.
+func unchangedSince(path, dataset, digest string, vars []string) bool {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	} else if err != nil {
+		panic(err)
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		panic(fmt.Sprintf("%s: %s", path, err))
+	}
+	if m.Digest == "" || m.Digest != digest || m.Dataset != dataset || len(m.Variables) != len(vars) {
+		return false
+	}
+	for i, v := range vars {
+		if m.Variables[i] != v {
+			return false
+		}
+	}
+	if !sameOutputOptions(m.Options, manifestOptions()) {
+		return false
+	}
+	logf("INFO", "dataset %s is unchanged since run %s, skipping query", dataset, m.RunID)
+	return true
+}
+
+// sameOutputOptions returns whether the options recorded in two manifests are the same,
+// apart from the cacheNeutralFlags which don't affect the output.
+func sameOutputOptions(a, b map[string]string) bool {
+	affecting := func(options map[string]string) map[string]string {
+		m := make(map[string]string, len(options))
+		for name, value := range options {
+			if !cacheNeutralFlags[name] {
+				m[name] = value
+			}
+		}
+		return m
+	}
+	a, b = affecting(a), affecting(b)
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if v, ok := b[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnchangedSince(t *testing.T) {
+	defer func() { userFilters = nil }()
+	path := filepath.Join(t.TempDir(), "manifest.json")
+	vars := []string{"city", "sex"}
+	if unchangedSince(path, "ds", "digest1", vars) {
+		t.Fatal("unchanged without a manifest")
+	}
+	if err := flag.Set("filter", "city=a"); err != nil {
+		t.Fatal(err)
+	}
+	writeManifest(path, time.Now(), "ds", "digest1", vars)
+
+	if !unchangedSince(path, "ds", "digest1", vars) {
+		t.Error("changed although the query is the same")
+	}
+	if unchangedSince(path, "ds", "digest2", vars) {
+		t.Error("unchanged although the digest changed")
+	}
+	if unchangedSince(path, "ds", "digest1", []string{"city"}) {
+		t.Error("unchanged although the variables changed")
+	}
+	defer func(n int) { *parallel = n }(*parallel)
+	if err := flag.Set("parallel", "8"); err != nil {
+		t.Fatal(err)
+	}
+	if !unchangedSince(path, "ds", "digest1", vars) {
+		t.Error("changed although only -parallel, which doesn't affect the output, changed")
+	}
+	if err := flag.Set("filter", "city=b"); err != nil {
+		t.Fatal(err)
+	}
+	if unchangedSince(path, "ds", "digest1", vars) {
+		t.Error("unchanged although the -filter changed")
+	}
+}
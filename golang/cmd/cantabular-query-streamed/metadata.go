@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// metadataCache stores the categories of variables in the metadata directory of
+// -cache-dir, so that checking filters and splitting queries over many runs don't
+// query the hundreds of thousands of categories of large variables each time.
+// Entries are keyed by the digest of the dataset, which changes whenever its data
+// does, so unlike outputs they never expire.
+type metadataCache struct {
+	dir     string
+	dataset string
+	digest  string
+}
+
+// openMetadataCache returns the metadata cache of dataset, or nil if there is no
+// -cache-dir. If the dataset digest cannot be obtained then a warning is printed
+// and nil is returned, in which case the cache should be bypassed.
+func openMetadataCache(ctx context.Context, dataset string) *metadataCache {
+	if *cacheDir == "" {
+		return nil
+	}
+	digest, err := datasetDigest(ctx, dataset)
+	if err != nil {
+		logf("WARNING", "not using metadata cache: %s", err)
+		return nil
+	}
+	return &metadataCache{dir: filepath.Join(*cacheDir, "metadata"), dataset: dataset, digest: digest}
+}
+
+// path returns the path of the entry of the categories of variable.
+func (m *metadataCache) path(variable string) string {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", apiURLs, m.dataset, m.digest, variable)
+	return filepath.Join(m.dir, hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// load returns the cached categories of variable, which are empty if it does not
+// exist, and whether there were any cached. -refresh-metadata ignores the cache.
+func (m *metadataCache) load(variable string) ([]variableCategories, bool) {
+	if m == nil || *refreshMetadata {
+		return nil, false
+	}
+	b, err := os.ReadFile(m.path(variable))
+	if err != nil {
+		return nil, false
+	}
+	var vcs []variableCategories
+	if err := json.Unmarshal(b, &vcs); err != nil {
+		logf("WARNING", "ignoring metadata cache entry for %s: %s", variable, err)
+		return nil, false
+	}
+	return vcs, true
+}
+
+// store caches the categories of variable. As the cache only saves queries, a
+// failure to write it is a warning rather than an error.
+func (m *metadataCache) store(variable string, vcs []variableCategories) {
+	if m == nil {
+		return
+	}
+	if vcs == nil {
+		vcs = []variableCategories{} // no such variable
+	}
+	if err := m.write(m.path(variable), vcs); err != nil {
+		logf("WARNING", "not caching categories of %s: %s", variable, err)
+	}
+}
+
+// write atomically replaces the entry at path with the JSON of vcs, so that runs
+// at once never read a partial entry.
+func (m *metadataCache) write(path string, vcs []variableCategories) error {
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(m.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if err := json.NewEncoder(tmp).Encode(vcs); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
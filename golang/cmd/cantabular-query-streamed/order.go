@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// orderCategoryValues returns the dimensions with their categories in the order
+// requested by -order-categories, and the values reindexed to match. Reordering needs
+// the whole table, so the values are read into memory unless the order is the source's.
+func orderCategoryValues(values cellValues, dims table.Dimensions) (cellValues, table.Dimensions) {
+	var less func(a, b table.Category) bool
+	switch *orderCategories {
+	case "source":
+		return values, dims
+	case "code":
+		less = func(a, b table.Category) bool { return lessCode(a.Code, b.Code) }
+	case "label":
+		less = func(a, b table.Category) bool {
+			if la, lb := strings.ToLower(a.Label), strings.ToLower(b.Label); la != lb {
+				return la < lb
+			}
+			return a.Label < b.Label
+		}
+	default:
+		panic(usageError("unknown -order-categories %q, expected code, label or source", *orderCategories))
+	}
+	var all []value.Value
+	for values.More() {
+		all = append(all, values.DecodeValue())
+	}
+	sorted, index := dims.Sorted(less)
+	if len(index) != len(all) {
+		panic(fmt.Sprintf("table has %d cells but %d values", len(index), len(all)))
+	}
+	reordered := make([]value.Value, len(all))
+	for i, j := range index {
+		reordered[i] = all[j]
+	}
+	return &valuesSlice{values: reordered}, sorted
+}
+
+// lessCode orders codes numerically if both are integers, and as strings otherwise,
+// so that "2" precedes "10".
+func lessCode(a, b string) bool {
+	na, errA := strconv.ParseInt(a, 10, 64)
+	nb, errB := strconv.ParseInt(b, 10, 64)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
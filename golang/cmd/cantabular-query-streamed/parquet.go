@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+func init() {
+	sink.Register("parquet", func(w io.Writer) sink.Sink { return &parquetSink{w: w, rowGroupSize: *rowGroupSize} })
+}
+
+// parquetSink writes Parquet, with the string columns as strings, integer columns
+// such as the row number as int64 and number columns as double. Measures are optional,
+// so that Null values may be written, and as a column has a single type Flagged values
+// are written as null too. Rows are buffered into row groups of rowGroupSize rows,
+// each of which is written once full, so memory use is bounded whatever the size of
+// the table.
+type parquetSink struct {
+	w            io.Writer
+	rowGroupSize int
+	pw           *parquet.Writer
+	types        []string
+	optional     []bool // whether each column may be null, as measures may
+	index        []int  // column index of each column in the schema
+	row          parquet.Row
+	rows         int
+}
+
+func (s *parquetSink) Open(meta sink.Metadata) error {
+	group := make(parquet.Group, len(meta.Columns))
+	names := make([]string, len(meta.Columns))
+	for i, c := range meta.Columns {
+		// Parquet column names must be unique, whereas CSV headers needn't be
+		name := c.Name
+		for n := 2; group[name] != nil; n++ {
+			name = fmt.Sprintf("%s_%d", c.Name, n)
+		}
+		names[i] = name
+		var node parquet.Node
+		switch c.Type {
+		case "string":
+			node = parquet.String()
+		case "integer":
+			node = parquet.Int(64)
+		default:
+			node = parquet.Leaf(parquet.DoubleType)
+		}
+		if c.Measure {
+			node = parquet.Optional(node)
+		}
+		group[name] = node
+		s.types = append(s.types, c.Type)
+		s.optional = append(s.optional, c.Measure)
+	}
+	schema := parquet.NewSchema("table", group)
+	// the schema orders columns by name, so find where each is
+	s.index = make([]int, len(names))
+	for i, name := range names {
+		leaf, _ := schema.Lookup(name)
+		s.index[i] = leaf.ColumnIndex
+	}
+	s.pw = parquet.NewWriter(s.w, schema)
+	s.row = make(parquet.Row, len(names))
+	return nil
+}
+
+func (s *parquetSink) WriteRow(row []value.Value) error {
+	for i, v := range row {
+		col := s.index[i]
+		if s.optional[i] && (v.Kind() == value.Null || v.Kind() == value.Flagged) {
+			s.row[col] = parquet.NullValue().Level(0, 0, col)
+			continue
+		}
+		var pv parquet.Value
+		switch s.types[i] {
+		case "string":
+			pv = parquet.ByteArrayValue([]byte(v.String()))
+		case "integer":
+			n, err := countInt(v)
+			if err != nil {
+				return err
+			}
+			pv = parquet.Int64Value(n)
+		default:
+			f, err := v.Float64()
+			if err != nil {
+				return err
+			}
+			pv = parquet.DoubleValue(f)
+		}
+		definition := 0
+		if s.optional[i] {
+			definition = 1
+		}
+		s.row[col] = pv.Level(0, definition, col)
+	}
+	if _, err := s.pw.WriteRows([]parquet.Row{s.row}); err != nil {
+		return err
+	}
+	if s.rows++; s.rowGroupSize > 0 && s.rows%s.rowGroupSize == 0 {
+		return s.pw.Flush()
+	}
+	return nil
+}
+
+func (s *parquetSink) Close() error {
+	return s.pw.Close()
+}
+
+// countInt returns a count, which must be a whole number to be stored as int64.
+// Fractional counts are stored as numbers if -decimals is given.
+func countInt(v value.Value) (int64, error) {
+	n, err := v.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("count %s cannot be written as int64, use -decimals for fractional values", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/cantabular/examples/pkg/jsonstream"
+)
+
+// runPassthrough posts the GraphQL query in queryPath, with the variables in the JSON
+// object in varsPath if it is not "", and writes the first table in the response to
+// stdout or -o. This allows queries other than cantabular.TableQuery, for instance with other
+// arguments to table or selecting other fields alongside it, to be converted.
+func runPassthrough(ctx context.Context, queryPath, varsPath string) {
+	query, err := os.ReadFile(queryPath)
+	if err != nil {
+		panic(err)
+	}
+	var variables map[string]interface{}
+	if varsPath != "" {
+		b, err := os.ReadFile(varsPath)
+		if err != nil {
+			panic(err)
+		}
+		// numbers are kept as written rather than converted to float64
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&variables); err != nil {
+			panic(usageError("-vars %s: %s", varsPath, err))
+		}
+	}
+	resp := postQuery(ctx, string(query), variables)
+	defer func() { _ = resp.Body.Close() }()
+	if *showProgress {
+		resp.Body = newProgressBody(resp.Body, resp.ContentLength)
+	}
+	r := gunzipped(resp.Body, resp.Header)
+	if *saveResponsePath != "" {
+		var closeSaved func()
+		r, closeSaved = saveResponse(ctx, r, *saveResponsePath)
+		defer closeSaved()
+	}
+	out, closeOut := openOutput()
+	defer closeOut()
+	passthroughJSONToCSV(r, out)
+}
+
+// passthroughJSONToCSV converts the first object named "table" anywhere in the data
+// of the JSON response in r, which must have the fields of a table in cantabular.TableQuery, to
+// CSV on w. Anything else in the response is skipped as it is read, so the table is
+// still streamed. It panics on error, including GraphQL errors and if there is no table.
+func passthroughJSONToCSV(r io.Reader, w io.Writer) {
+	dec := jsonstream.New(r)
+	if !dec.StartObjectComposite() {
+		panic("No JSON object found in response")
+	}
+	tables := 0
+	for dec.More() {
+		switch field := dec.DecodeName(); field {
+		case "data":
+			findTables(dec, nextToken(dec), w, &tables)
+		case "errors":
+			decodeErrorsPanicIfAny(dec)
+		default:
+			dec.SkipValue()
+		}
+	}
+	dec.EndComposite()
+	switch {
+	case tables == 0:
+		panic("no table found in response")
+	case tables > 1:
+		logf("WARNING", "%d tables found in response, only the first was converted", tables)
+	}
+}
+
+// findTables walks the JSON value beginning with tok, converting the first table
+// object found within it to CSV on w and counting the tables in *tables.
+func findTables(dec jsonstream.Decoder, tok json.Token, w io.Writer, tables *int) {
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			name := dec.DecodeName()
+			tok := nextToken(dec)
+			if name == "table" && tok == json.Delim('{') {
+				*tables++
+				if *tables == 1 {
+					decodeTableFields(dec, w)
+					dec.EndComposite()
+					continue
+				}
+			}
+			findTables(dec, tok, w, tables)
+		}
+		dec.EndComposite()
+	case json.Delim('['):
+		for dec.More() {
+			findTables(dec, nextToken(dec), w, tables)
+		}
+		dec.EndComposite()
+	}
+}
+
+// nextToken returns the next token of dec. It panics on error.
+func nextToken(dec jsonstream.Decoder) json.Token {
+	tok, err := dec.Token()
+	if err != nil {
+		panic(err)
+	}
+	return tok
+}
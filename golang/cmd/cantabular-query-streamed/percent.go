@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// percentDimension returns the index of the dimension over which -percent computes
+// percentages: the last for row, the first for col, that of a named variable, or -1
+// for total, which is over every dimension.
+func percentDimension(dims table.Dimensions) int {
+	switch *percent {
+	case "total":
+		return -1
+	case "row":
+		return len(dims) - 1
+	case "col":
+		return 0
+	}
+	for i, d := range dims {
+		if d.Variable.Name == *percent {
+			return i
+		}
+	}
+	panic(usageError("unknown -percent %q, expected row, col, total or a variable of the table", *percent))
+}
+
+// percentValues are cellValues which also give the percentage that each value is of its
+// margin over the -percent dimension, see table.Margins. The values are read ahead a
+// block at a time to find the margins of the block, so for row only a row is held in
+// memory, whereas col and total hold the whole table.
+type percentValues struct {
+	values   cellValues
+	margins  *table.Margins
+	block    []value.Value
+	percents []value.Value // percentages of the values of the block
+	next     int
+}
+
+func newPercentValues(values cellValues, dims table.Dimensions) *percentValues {
+	return &percentValues{values: values, margins: table.NewMargins(dims, percentDimension(dims))}
+}
+
+func (p *percentValues) More() bool {
+	if p.next < len(p.block) {
+		return true
+	}
+	p.block, p.next = p.block[:0], 0
+	p.margins.Reset()
+	for len(p.block) < p.margins.BlockSize() && p.values.More() {
+		v := p.values.DecodeValue()
+		p.margins.Add(len(p.block), v)
+		p.block = append(p.block, v)
+	}
+	p.percentBlock()
+	return len(p.block) > 0
+}
+
+func (p *percentValues) DecodeValue() value.Value {
+	v := p.block[p.next]
+	p.next++
+	return v
+}
+
+// percentage returns the percentage of the value last decoded.
+func (p *percentValues) percentage() value.Value {
+	return p.percents[p.next-1]
+}
+
+// percentBlock finds the percentages of the values of the block, with -decimals places
+// or one by default, rounded to sum to 100 if -percent-sum-100.
+func (p *percentValues) percentBlock() {
+	decimals := *decimals
+	if decimals < 0 {
+		decimals = 1
+	}
+	p.percents = p.percents[:0]
+	for i, v := range p.block {
+		percent := value.Value{} // a cell without a number has no percentage
+		if f, ok := v.Number(); ok {
+			percentage := 0.0
+			if total := p.margins.Total(i); total != 0 {
+				percentage = 100 * f / total
+			}
+			percent = value.NewFloat(percentage, decimals)
+		}
+		p.percents = append(p.percents, percent)
+	}
+	if *percentSum100 {
+		p.sumTo100(decimals)
+	}
+}
+
+// sumTo100 rounds the percentages of each margin of the block so that they sum to
+// exactly 100, by the largest remainder method: each is rounded down to decimals
+// places, and then those with the largest remainders are rounded up instead until
+// they sum to 100. So no percentage differs from its exact value by a unit of the
+// last place or more. The percentages of a zero total, or of one with negative values
+// which cannot be rounded to sum to 100 that way, are left rounded independently.
+func (p *percentValues) sumTo100(decimals int) {
+	type cell struct {
+		i         int     // index in the block
+		units     float64 // of the last place, rounded down
+		remainder float64
+	}
+	scale := math.Pow10(decimals)
+	margins := make([][]cell, p.margins.Count())
+	for i, v := range p.block {
+		f, ok := v.Number()
+		total := p.margins.Total(i)
+		if !ok || total == 0 {
+			continue
+		}
+		exact := 100 * f / total * scale
+		units := math.Floor(exact)
+		m := p.margins.Index(i)
+		margins[m] = append(margins[m], cell{i, units, exact - units})
+	}
+	for _, cells := range margins {
+		sum := 0.0
+		for _, c := range cells {
+			sum += c.units
+		}
+		short := int(math.Round(100*scale - sum)) // units to round up
+		if short < 0 || short > len(cells) {
+			continue
+		}
+		sort.SliceStable(cells, func(a, b int) bool { return cells[a].remainder > cells[b].remainder })
+		for k, c := range cells {
+			if k < short {
+				c.units++
+			}
+			p.percents[c.i] = value.NewFloat(c.units/scale, decimals)
+		}
+	}
+}
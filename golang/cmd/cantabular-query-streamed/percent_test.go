@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+func TestPercentSum100(t *testing.T) {
+	defer func(sum100 bool, places int) { *percentSum100, *decimals = sum100, places }(*percentSum100, *decimals)
+	*decimals = 0
+	dims := make(table.Dimensions, 2)
+	dims[0].Count, dims[1].Count = 3, 3
+	// the percentages are of the columns: thirds, which round to 33 each, and 1/6 and
+	// 5/6, which round to 17 and 83 so already sum to 100
+	counts := []float64{1, 0, 2, 1, 1, 2, 1, 5, 2}
+	for _, tc := range []struct {
+		sum100 bool
+		want   []string
+	}{
+		{false, []string{"33", "0", "33", "33", "17", "33", "33", "83", "33"}},
+		{true, []string{"34", "0", "34", "33", "17", "33", "33", "83", "33"}},
+	} {
+		*percentSum100 = tc.sum100
+		p := &percentValues{margins: table.NewMargins(dims, 0)}
+		for i, f := range counts {
+			v := value.NewFloat(f, -1)
+			p.margins.Add(i, v)
+			p.block = append(p.block, v)
+		}
+		p.percentBlock()
+		for i, want := range tc.want {
+			if got := p.percents[i].String(); got != want {
+				t.Errorf("-percent-sum-100=%t: percentage of cell %d is %s, want %s", tc.sum100, i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// pivotLast returns vars with the -pivot variable moved to the end, so that in the
+// row-major order of the values the cells of each row of the pivoted table are
+// consecutive, and only one row need be held at a time.
+func pivotLast(vars []string) []string {
+	if *pivot == "" {
+		return vars
+	}
+	moved := make([]string, 0, len(vars))
+	for _, v := range vars {
+		if v != *pivot {
+			moved = append(moved, v)
+		}
+	}
+	if len(moved) == len(vars) {
+		panic(usageError("-pivot %s is not one of the variables of the query", *pivot))
+	}
+	return append(moved, *pivot)
+}
+
+// checkPivot panics unless the table can be pivoted: the -pivot variable must be
+// its last dimension, as it is of a query made with -pivot but may not be of a
+// response saved without it, and there is a single count of each cell.
+func checkPivot(dims table.Dimensions) {
+	switch {
+	case len(dims) == 0 || dims[len(dims)-1].Variable.Name != *pivot:
+		panic(usageError("-pivot %s must be the last variable of the table, as it is of a query made with -pivot", *pivot))
+	case *showTotals:
+		panic(usageError("-totals cannot be combined with -pivot"))
+	case *percent != "":
+		panic(usageError("-percent cannot be combined with -pivot"))
+	}
+}
+
+// pivotColumns returns the columns of the counts of a pivoted table, one for each
+// category of the -pivot dimension, which is the last, headed by its label.
+func pivotColumns(dims table.Dimensions, count column) []column {
+	d := dims[len(dims)-1]
+	category := categoryFunc()
+	columns := make([]column, 0, len(d.Categories))
+	for _, c := range d.Categories {
+		col := count
+		col.Name, col.Variable = category(c), d.Variable.Name
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// allZero returns whether every count is zero, for -skip-zeros.
+func allZero(counts []value.Value) bool {
+	for _, v := range counts {
+		if f, ok := v.Number(); !ok || f != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often -progress is reported.
+const progressInterval = 2 * time.Second
+
+// rowsWritten counts the rows written to the outputs, for -progress.
+var rowsWritten atomic.Int64
+
+// progressBody wraps a response body so that the bytes read from it are counted and,
+// until it is closed, progress is reported to stderr every progressInterval: the bytes
+// read, the rows written and the rate at which they are, and, if the length of the
+// response is known, the estimated time until it has all been read.
+type progressBody struct {
+	io.ReadCloser
+	length  int64 // -1 if unknown
+	read    atomic.Int64
+	rows    int64 // rowsWritten when the body was opened
+	started time.Time
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newProgressBody(body io.ReadCloser, length int64) *progressBody {
+	b := &progressBody{
+		ReadCloser: body,
+		length:     length,
+		rows:       rowsWritten.Load(),
+		started:    time.Now(),
+		done:       make(chan struct{}),
+	}
+	go func() {
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				b.report()
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return b
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read.Add(int64(n))
+	return n, err
+}
+
+// Close stops reporting, reports the final progress and closes the body.
+func (b *progressBody) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.report()
+	})
+	return b.ReadCloser.Close()
+}
+
+// report writes the progress to stderr as a single line of key=value pairs.
+func (b *progressBody) report() {
+	elapsed := time.Since(b.started)
+	read, rows := b.read.Load(), rowsWritten.Load()-b.rows
+	eta := "unknown"
+	if b.length > 0 && read > 0 {
+		remaining := time.Duration(float64(elapsed) * float64(b.length-read) / float64(read))
+		eta = remaining.Round(time.Second).String()
+	}
+	logf("PROGRESS", "bytes=%d length=%d rows=%d rate=%.0frows/s elapsed=%s eta=%s",
+		read, b.length, rows, float64(rows)/elapsed.Seconds(), elapsed.Round(time.Second), eta)
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reader is an io.Reader which limits the rate at which data
+// can be read from the wrapped reader to a fixed number of bytes per second.
+type Reader struct {
+	r io.Reader
+	l limiter
+}
+
+// NewReader creates a Reader limited to bytesPerSec.
+func NewReader(r io.Reader, bytesPerSec int64) *Reader {
+	return &Reader{r: r, l: newLimiter(bytesPerSec)}
+}
+
+// Read reads at most a tenth of a second's worth of data and then
+// sleeps for as long as is needed to keep within the limit.
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) > r.l.chunk {
+		p = p[:r.l.chunk]
+	}
+	n, err := r.r.Read(p)
+	r.l.wait(n)
+	return n, err
+}
+
+// limiter keeps track of the bytes transferred since it was created
+// and computes how long to sleep to keep the average rate within the limit.
+type limiter struct {
+	bytesPerSec int64
+	chunk       int
+	start       time.Time
+	total       int64
+}
+
+func newLimiter(bytesPerSec int64) limiter {
+	if bytesPerSec <= 0 {
+		panic("ratelimit: bytes per second must be positive")
+	}
+	chunk := int(bytesPerSec / 10)
+	if chunk < 1 {
+		chunk = 1
+	}
+	return limiter{bytesPerSec: bytesPerSec, chunk: chunk}
+}
+
+func (l *limiter) wait(n int) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	l.total += int64(n)
+	due := time.Duration(float64(l.total) / float64(l.bytesPerSec) * float64(time.Second))
+	if ahead := due - time.Since(l.start); ahead > 0 {
+		time.Sleep(ahead)
+	}
+}
+
+// units maps the accepted unit suffixes to their size in bytes.
+// Decimal units are powers of 1000 and binary units are powers of 1024.
+var units = []struct {
+	suffix string
+	size   float64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// ParseBandwidth parses a bandwidth such as "10MB/s", "512KiB/s" or "2000"
+// and returns the number of bytes per second. The "/s" suffix is optional
+// and a number without a unit is a number of bytes.
+func ParseBandwidth(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	size := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(num, u.suffix) {
+			num, size = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.size
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q", s)
+	}
+	bytesPerSec := int64(f * size)
+	if bytesPerSec <= 0 {
+		return 0, fmt.Errorf("bandwidth %q must be positive", s)
+	}
+	return bytesPerSec, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/source"
+)
+
+// replay converts every response saved with -save-response in dir using the current
+// options and compares each output with the one recorded next to the response by a
+// previous replay, e.g. table.csv for table.json.gz. Missing outputs are recorded,
+// as are all outputs if update is true. This verifies that an upgrade of the client
+// doesn't change any table it produces. It reports to stdout and returns the number
+// of responses whose output differed or could not be converted.
+func replay(dir string, update bool) (failed int) {
+	var paths []string
+	for _, pattern := range []string{"*.json", "*.json.gz"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			panic(err)
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		got, err := replayResponse(path)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s\n", name, err)
+			failed++
+			continue
+		}
+		recorded := strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".json") + ".csv"
+		want, err := os.ReadFile(recorded)
+		switch {
+		case update || os.IsNotExist(err):
+			if err := os.WriteFile(recorded, got, 0o644); err != nil {
+				panic(err)
+			}
+			fmt.Printf("RECORDED %s\n", name)
+		case err != nil:
+			panic(err)
+		case !bytes.Equal(got, want):
+			fmt.Printf("FAIL %s: %s\n", name, firstDifference(got, want))
+			failed++
+		default:
+			fmt.Printf("OK %s\n", name)
+		}
+	}
+	return failed
+}
+
+// replayResponse converts the saved response at path to CSV, recovering from conversion panics.
+func replayResponse(path string) (output []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s", r)
+		}
+	}()
+	raw, err := readSavedResponse(path)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	graphqlJSONToCSV(bytes.NewReader(raw), &b)
+	return b.Bytes(), nil
+}
+
+// convertSaved converts the GraphQL response saved at path, or read from stdin if path
+// is "-", to the output as the response to a query would be. The response is streamed,
+// so may be of any size. It panics on error.
+func convertSaved(path string) {
+	r, err := source.File(path).Open(context.Background(), source.Query{})
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = r.Close() }()
+	out, closeOut := openOutput()
+	defer closeOut()
+	graphqlJSONToCSV(r, out)
+}
+
+// readSavedResponse reads a response saved with -save-response, decompressing it if its name ends in ".gz".
+func readSavedResponse(path string) ([]byte, error) {
+	r, err := source.File(path).Open(context.Background(), source.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	return io.ReadAll(r)
+}
+
+// firstDifference describes the first line at which got differs from want.
+func firstDifference(got, want []byte) string {
+	gotLines, wantLines := bytes.Split(got, []byte("\n")), bytes.Split(want, []byte("\n"))
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(gotLines):
+			return fmt.Sprintf("output ends at line %d but recorded output continues", i)
+		case i >= len(wantLines):
+			return fmt.Sprintf("recorded output ends at line %d but output continues", i)
+		case !bytes.Equal(gotLines[i], wantLines[i]):
+			return fmt.Sprintf("line %d is %q but recorded %q", i+1, gotLines[i], wantLines[i])
+		}
+	}
+}
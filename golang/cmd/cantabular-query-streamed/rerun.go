@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// rerunCommand runs the query recorded in the manifest in args again, with the same
+// URL and options except those given on this command line, which replace them. With
+// -verify-digest it fails rather than query a dataset which has changed since.
+func rerunCommand(ctx context.Context, args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+	m := readManifest(args[0])
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if !given["u"] {
+		if err := apiURLs.Set(m.URL); err != nil {
+			panic(fmt.Sprintf("%s: url: %s", args[0], err))
+		}
+	}
+	if *verifyDigest {
+		if m.Digest == "" {
+			panic(fmt.Sprintf("%s: no digest of dataset %s was recorded", args[0], m.Dataset))
+		}
+		digest, err := datasetDigest(ctx, m.Dataset)
+		if err != nil {
+			panic(err)
+		}
+		if digest != m.Digest {
+			panic(fmt.Sprintf("dataset %s has changed since run %s: its digest was %s and is now %s",
+				m.Dataset, m.RunID, m.Digest, digest))
+		}
+	}
+	cmdArgs := append([]string{"-u=" + apiURLs.String()}, manifestArgs(m, given)...)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "u" && f.Name != "verify-digest" && !secretFlags[f.Name] {
+			cmdArgs = append(cmdArgs, optionArgs(f.Name, f.Value.String())...)
+		}
+	})
+	cmdArgs = append(cmdArgs, secretArgs()...)
+	cmdArgs = append(append(cmdArgs, "query", m.Dataset), m.Variables...)
+	logf("INFO", "re-running run=%s of %s", m.RunID, args[0])
+	runSelf(ctx, cmdArgs)
+}
+
+// readManifest reads the manifest of a query at path.
+func readManifest(path string) manifest {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	var m manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		panic(fmt.Sprintf("%s: %s", path, err))
+	}
+	if m.Dataset == "" || len(m.Variables) == 0 {
+		panic(fmt.Sprintf("%s: not the manifest of a query", path))
+	}
+	return m
+}
+
+// manifestArgs returns the arguments setting the options recorded in m, in order of
+// name, except those given on this command line, which replace them.
+func manifestArgs(m manifest, given map[string]bool) []string {
+	names := make([]string, 0, len(m.Options))
+	for name := range m.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		switch {
+		case given[name]:
+			continue
+		case name == "f" || name == "filter":
+			// -filter is the same as -f, so both record every filter
+			if given["f"] || given["filter"] || name == "filter" && m.Options["f"] != "" {
+				continue
+			}
+		}
+		args = append(args, optionArgs(name, m.Options[name])...)
+	}
+	return args
+}
+
+// optionArgs returns the arguments setting the flag with the name to value, as
+// recorded in a manifest. The filters of -f are recorded separated by spaces.
+func optionArgs(name, value string) []string {
+	if name != "f" && name != "filter" {
+		return []string{"-" + name + "=" + value}
+	}
+	var args []string
+	for _, f := range strings.Fields(value) {
+		args = append(args, "-f="+f)
+	}
+	return args
+}
+
+// runSelf runs this program with args and any extra environment variables, as
+// name=value, exiting with its exit code if it fails.
+func runSelf(ctx context.Context, args []string, env ...string) {
+	exe, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,55 @@
+package rounding
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Methods are the supported rounding methods:
+//
+//   - "nearest" rounds to the nearest multiple of the base, with halves rounded up.
+//     It is deterministic so the same table is always rounded the same way.
+//   - "random" rounds to one of the two nearest multiples of the base, choosing the
+//     further one with a probability proportional to how near the value is to it, so
+//     that on average the rounded values sum to the original total. The choices are
+//     made by a pseudo-random generator so the same seed reproduces the same output.
+var Methods = []string{"nearest", "random"}
+
+// Rounder rounds values to a multiple of a base.
+type Rounder struct {
+	base float64
+	rnd  *rand.Rand
+}
+
+// New creates a Rounder for the named method. The seed is only used by the random method.
+func New(base int, method string, seed int64) (*Rounder, error) {
+	if base < 1 {
+		return nil, fmt.Errorf("rounding base must be positive but got %d", base)
+	}
+	r := &Rounder{base: float64(base)}
+	switch method {
+	case "nearest":
+	case "random":
+		r.rnd = rand.New(rand.NewSource(seed))
+	default:
+		return nil, fmt.Errorf("unknown rounding method %q, expected one of %q", method, Methods)
+	}
+	return r, nil
+}
+
+// Round returns v rounded to a multiple of the base.
+func (r *Rounder) Round(v float64) float64 {
+	lower := math.Floor(v/r.base) * r.base
+	remainder := v - lower
+	if r.rnd == nil {
+		if remainder*2 >= r.base {
+			return lower + r.base
+		}
+		return lower
+	}
+	if remainder > 0 && r.rnd.Float64()*r.base < remainder {
+		return lower + r.base
+	}
+	return lower
+}
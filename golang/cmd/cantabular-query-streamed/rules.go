@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// writeRuleReport writes the rule variable of the dataset and the disclosure control
+// status of the table of vars, see cantabular.RuleReport, as JSON to the file at path.
+// A blocked table is reported rather than being an error. It panics on error.
+func writeRuleReport(ctx context.Context, path, dataset string, vars []string) {
+	var data struct {
+		Dataset struct {
+			RuleBase *struct{ Name string }
+			Table    cantabular.Table
+		}
+	}
+	queryData(ctx, cantabular.RulesQuery, map[string]interface{}{
+		"dataset":   dataset,
+		"variables": vars,
+		"filters":   userFilters,
+	}, &data)
+	t := data.Dataset.Table
+	report := cantabular.RuleReport{
+		Dataset:   dataset,
+		Variables: vars,
+		Rules:     t.Rules,
+		Blocked:   t.Error != "",
+		Error:     t.Error,
+	}
+	for _, f := range userFilters {
+		report.Filters = append(report.Filters, cantabular.Filter(f))
+	}
+	if data.Dataset.RuleBase != nil {
+		report.RuleVariable = data.Dataset.RuleBase.Name
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		panic(outputError(err))
+	}
+	if report.Blocked {
+		logf("WARNING", "table blocked: %s, see %s", report.Error, path)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// saveResponse returns a reader which copies the response read from body to the file
+// at path, gzip compressed if path ends in ".gz". The returned function must be
+// deferred once conversion has begun: it reads any remainder of the response so that
+// the saved copy is complete, unless -limit rows have been written or ctx is done, and
+// closes the file. It only panics if closing fails and no panic is already in flight.
+func saveResponse(ctx context.Context, body io.Reader, path string) (io.Reader, func()) {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(outputError(err))
+	}
+	var w io.WriteCloser = f
+	if strings.HasSuffix(path, ".gz") {
+		w = gzip.NewWriter(f)
+	}
+	tee := io.TeeReader(body, w)
+	return tee, func() {
+		p := recover()
+		if limitReached.Load() || ctx.Err() != nil {
+			logf("WARNING", "the response saved to %s is partial, as the rest wasn't read", path)
+		} else {
+			_, _ = io.Copy(io.Discard, tee)
+		}
+		var err error
+		if w != f {
+			err = w.Close()
+		}
+		if fErr := f.Close(); err == nil {
+			err = fErr
+		}
+		if p != nil {
+			panic(p)
+		}
+		if err != nil {
+			panic(outputError(err))
+		}
+	}
+}
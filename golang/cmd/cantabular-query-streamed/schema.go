@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// column describes a column of the CSV output, as written to the -schema file so
+// that tables for the output can be created without inspecting it.
+type column = sink.Column
+
+// csvColumns returns the columns of the CSV output of a table with the dimensions,
+// renamed if -snake-case-headers is given.
+func csvColumns(dims table.Dimensions) []column {
+	columns := make([]column, 0, 2*len(dims)+2)
+	if *rowNumbers {
+		columns = append(columns, column{Name: "row", Type: "integer", Content: "row"})
+	}
+	content := "label"
+	if *combinedLabels != "" {
+		content = "combined"
+	}
+	dimensions := dims
+	if *pivot != "" {
+		dimensions = dims[:len(dims)-1] // its categories head the counts
+	}
+	for _, d := range dimensions {
+		columns = append(columns, column{Name: d.Variable.Label, Variable: d.Variable.Name, Type: "string", Content: content})
+		if *codes {
+			columns = append(columns, column{Name: d.Variable.Name + "_code", Variable: d.Variable.Name, Type: "string", Content: "code"})
+		}
+	}
+	columns = append(columns, geoColumns(dimensions)...)
+	count := column{Name: "count", Type: "integer", Content: "count", Measure: true}
+	if *decimals >= 0 {
+		count.Type = "number"
+	}
+	if *pivot != "" {
+		columns = append(columns, pivotColumns(dims, count)...)
+	} else {
+		columns = append(columns, count)
+	}
+	if *percent != "" {
+		columns = append(columns, column{Name: "percent", Type: "number", Content: "percent", Measure: true})
+	}
+	if *snakeCaseHeaders {
+		snakeCaseColumns(columns)
+	}
+	return columns
+}
+
+// schemaOnce ensures the schema is written once when encoding several -format at once.
+var schemaOnce sync.Once
+
+// writeSchema writes the columns of the CSV output to the JSON file at path. It panics on error.
+func writeSchema(path string, columns []column) {
+	schemaOnce.Do(func() { writeSchemaFile(path, columns) })
+}
+
+func writeSchemaFile(path string, columns []column) {
+	b, err := json.MarshalIndent(struct {
+		Format  string   `json:"format"`
+		Columns []column `json:"columns"`
+	}{"csv", columns}, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		panic(outputError(err))
+	}
+}
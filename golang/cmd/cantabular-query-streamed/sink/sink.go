@@ -0,0 +1,85 @@
+// Package sink defines the outputs to which tables are written, so that outputs
+// other than those built in may be added by registering them.
+package sink
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cantabular/examples/pkg/value"
+)
+
+type (
+	// Column describes a column of the rows written to a sink.
+	Column struct {
+		Name     string `json:"name"`               // header of the column
+		Variable string `json:"variable,omitempty"` // name of the variable the column is a dimension of
+		Type     string `json:"type"`               // integer, number or string
+		Content  string `json:"content"`            // row, label, combined, code, identifier, count or percent
+		Measure  bool   `json:"measure"`            // whether the column is a measure rather than a dimension
+	}
+
+	// Metadata describes the table written to a sink.
+	Metadata struct {
+		Columns   []Column
+		Variables []string // names of the variables of the table
+		Path      string   // output path, for sinks such as databases which open it themselves
+	}
+
+	// Sink writes the rows of a table to an output.
+	Sink interface {
+		// Open begins the output of a table described by meta.
+		Open(meta Metadata) error
+		// WriteRow writes a row with a value for each column. Dimension columns are
+		// strings, and measures numbers of the Type of the column, Null or Flagged.
+		// The row may be reused once WriteRow returns.
+		WriteRow(row []value.Value) error
+		// Close completes the output. A table is only complete if Close succeeds.
+		Close() error
+	}
+
+	// Flusher is implemented by sinks which buffer rows and can write them on request,
+	// so that readers of the output see them promptly.
+	Flusher interface {
+		Flush() error
+	}
+
+	// Factory returns a new sink writing to w.
+	Factory func(w io.Writer) Sink
+)
+
+var factories = make(map[string]Factory)
+
+// Register makes a sink available by name. It panics if the name is already registered.
+func Register(name string, f Factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("sink %q registered twice", name))
+	}
+	factories[name] = f
+}
+
+// New returns a new sink of the registered name writing to w.
+func New(name string, w io.Writer) (Sink, error) {
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown sink %q, expected one of %v", name, Names())
+	}
+	return f(w), nil
+}
+
+// Names returns the names of the registered sinks in order.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Registered returns whether a sink of the name is registered.
+func Registered(name string) bool {
+	_, ok := factories[name]
+	return ok
+}
@@ -0,0 +1,46 @@
+package source
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("file", func(arg string) (Source, error) {
+		if arg == "" {
+			return nil, errors.New("file source requires a path, e.g. file:table.json")
+		}
+		return File(arg), nil
+	})
+}
+
+// File is a source which returns the response saved in the file at its path, or read
+// from stdin if the path is "-", whatever the query. The response is decompressed if
+// the path ends in ".gz".
+type File string
+
+func (path File) Open(context.Context, Query) (io.ReadCloser, error) {
+	var f io.ReadCloser = os.Stdin
+	if path != "-" {
+		var err error
+		if f, err = os.Open(string(path)); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasSuffix(string(path), ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
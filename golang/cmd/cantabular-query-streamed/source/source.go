@@ -0,0 +1,77 @@
+// Package source defines where the GraphQL responses containing tables come from,
+// so that the conversion of responses to outputs can be used with sources other
+// than the extended API, such as saved responses or generated tables.
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type (
+	// Filter restricts a variable to the given category codes in a table query.
+	Filter struct {
+		Variable string   `json:"variable"`
+		Codes    []string `json:"codes"`
+	}
+
+	// Query describes the table requested from a source.
+	Query struct {
+		Dataset   string
+		Variables []string
+		Filters   []Filter
+	}
+
+	// Source produces the GraphQL response containing a table, in the form the
+	// extended API returns it: {"data":{"dataset":{"table":{...}}}}.
+	Source interface {
+		// Open returns the response for the query, which the caller must close.
+		Open(ctx context.Context, q Query) (io.ReadCloser, error)
+	}
+
+	// Func is a function which is a Source.
+	Func func(ctx context.Context, q Query) (io.ReadCloser, error)
+
+	// Factory returns a new source given the argument following the name of the
+	// source in a specification, see New.
+	Factory func(arg string) (Source, error)
+)
+
+// Open calls f.
+func (f Func) Open(ctx context.Context, q Query) (io.ReadCloser, error) {
+	return f(ctx, q)
+}
+
+var factories = make(map[string]Factory)
+
+// Register makes a source available by name. It panics if the name is already registered.
+func Register(name string, f Factory) {
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("source %q registered twice", name))
+	}
+	factories[name] = f
+}
+
+// New returns a new source given a specification of its registered name, optionally
+// followed by a colon and an argument for the source, e.g. file:table.json.gz.
+func New(spec string) (Source, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	f, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown source %q, expected one of %v", name, Names())
+	}
+	return f(arg)
+}
+
+// Names returns the names of the registered sources in order.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
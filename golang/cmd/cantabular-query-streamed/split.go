@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cantabular/examples/pkg/table"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// splitQuery is the fallback for a table query which repeatedly times out at the gateway.
+// The categories of the largest dimension are split in two and each half is queried
+// separately, splitting again as required. The results are then stitched back together
+// into a single GraphQL response which can be converted as if it came from the API.
+// Unlike the normal path the whole table is held in memory. It panics on error.
+func splitQuery(ctx context.Context, dataset string, vars []string) io.ReadCloser {
+	variable, codes := largestVariable(ctx, dataset, vars)
+	dims, values := querySplit(ctx, dataset, vars, variable, codes)
+	b, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{
+			"dataset": map[string]interface{}{
+				"table": map[string]interface{}{
+					"dimensions": dims,
+					"values":     values,
+					"error":      nil,
+				},
+			},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return io.NopCloser(bytes.NewReader(b))
+}
+
+// largestVariable returns the name and category codes of the variable with the most categories,
+// taking account of -filter.
+func largestVariable(ctx context.Context, dataset string, vars []string) (string, []string) {
+	var name string
+	var codes []string
+	for _, vc := range categoryCodes(ctx, dataset, vars) {
+		if filtered := userFilters.codes(vc.Name); filtered != nil {
+			vc.Codes = filtered
+		}
+		if len(vc.Codes) > len(codes) {
+			name, codes = vc.Name, vc.Codes
+		}
+	}
+	return name, codes
+}
+
+// querySplit obtains the table with variable restricted to codes, splitting codes in two
+// if the query times out. Any other -filter applies to each part.
+func querySplit(ctx context.Context, dataset string, vars []string, variable string, codes []string) (table.Dimensions, []value.Value) {
+	if body := queryTable(ctx, dataset, vars, userFilters.with(variable, codes)); body != nil {
+		defer func() { _ = body.Close() }()
+		return decodeTable(body)
+	}
+	if len(codes) < 2 {
+		panic(fmt.Sprintf("%s: query timed out with %s restricted to a single category",
+			http.StatusText(http.StatusGatewayTimeout), variable))
+	}
+	mid := len(codes) / 2
+	dims, values := querySplit(ctx, dataset, vars, variable, codes[:mid])
+	dims2, values2 := querySplit(ctx, dataset, vars, variable, codes[mid:])
+	return stitch(variable, dims, values, dims2, values2)
+}
+
+// decodeTable decodes a whole GraphQL table response.
+func decodeTable(r io.Reader) (table.Dimensions, []value.Value) {
+	var gqlResp struct {
+		Data struct {
+			Dataset struct {
+				Table struct {
+					Dimensions table.Dimensions
+					Values     []value.Value
+					Error      *string
+				}
+			}
+		}
+		Errors []struct{ Message string }
+	}
+	if err := json.NewDecoder(r).Decode(&gqlResp); err != nil {
+		panic(err)
+	}
+	if len(gqlResp.Errors) > 0 {
+		panic(graphQLError(gqlResp.Errors[0].Message))
+	}
+	t := gqlResp.Data.Dataset.Table
+	if t.Error != nil {
+		panic(blockedError(*t.Error))
+	}
+	return t.Dimensions, t.Values
+}
+
+// stitch combines two tables which differ only in the categories of the dimension for
+// variable. The categories of the second table follow those of the first.
+func stitch(variable string, dims table.Dimensions, values []value.Value,
+	dims2 table.Dimensions, values2 []value.Value) (table.Dimensions, []value.Value) {
+	d := 0
+	for d < len(dims) && dims[d].Variable.Name != variable {
+		d++
+	}
+	if d == len(dims) {
+		panic(fmt.Sprintf("variable %q missing from split table", variable))
+	}
+	// values are in row-major order, so for each combination of categories of
+	// the outer dimensions there is a contiguous block for the split dimension.
+	inner := 1
+	for _, dim := range dims[d+1:] {
+		inner *= dim.Count
+	}
+	block, block2 := dims[d].Count*inner, dims2[d].Count*inner
+	stitched := make([]value.Value, 0, len(values)+len(values2))
+	for i, j := 0, 0; i < len(values) && j < len(values2); i, j = i+block, j+block2 {
+		stitched = append(stitched, values[i:i+block]...)
+		stitched = append(stitched, values2[j:j+block2]...)
+	}
+	dims[d].Count += dims2[d].Count
+	dims[d].Categories = append(dims[d].Categories, dims2[d].Categories...)
+	return dims, stitched
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+func init() {
+	sink.Register("sqlite", func(io.Writer) sink.Sink { return &sqliteSink{} })
+}
+
+// sqliteSink inserts the rows into a new table of the SQLite database at the path of
+// the output, with integer columns such as the row number as INTEGER and number
+// columns as REAL. Measures may be NULL, and SQLite stores the flags of Flagged
+// values as text whatever the type of their column. Rows are inserted within a transaction as they are written, so
+// the table only appears if the sink is closed.
+type sqliteSink struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	insert *sql.Stmt
+	args   []interface{}
+	name   string
+	path   string
+}
+
+func (s *sqliteSink) Open(meta sink.Metadata) error {
+	var err error
+	if s.db, err = sql.Open("sqlite", meta.Path); err != nil {
+		return err
+	}
+	if s.tx, err = s.db.Begin(); err != nil {
+		return err
+	}
+	if s.name, err = sqliteTableName(s.tx, meta.Variables); err != nil {
+		return err
+	}
+	defs := make([]string, 0, len(meta.Columns))
+	for _, c := range meta.Columns {
+		typ := "REAL"
+		switch c.Type {
+		case "string":
+			typ = "TEXT"
+		case "integer":
+			typ = "INTEGER"
+		}
+		if !c.Measure {
+			typ += " NOT NULL"
+		}
+		defs = append(defs, fmt.Sprintf("%s %s", quoteIdent(c.Name), typ))
+	}
+	if _, err := s.tx.Exec(fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(s.name), strings.Join(defs, ", "))); err != nil {
+		return err
+	}
+	s.insert, err = s.tx.Prepare(fmt.Sprintf("INSERT INTO %s VALUES (%s)",
+		quoteIdent(s.name), strings.TrimSuffix(strings.Repeat("?, ", len(meta.Columns)), ", ")))
+	s.path = meta.Path
+	return err
+}
+
+func (s *sqliteSink) WriteRow(row []value.Value) error {
+	s.args = s.args[:0]
+	for _, v := range row {
+		switch v.Kind() {
+		case value.Null:
+			s.args = append(s.args, nil)
+		case value.Int:
+			n, _ := v.Int64()
+			s.args = append(s.args, n)
+		case value.Float:
+			f, _ := v.Float64()
+			s.args = append(s.args, f)
+		default:
+			s.args = append(s.args, v.String())
+		}
+	}
+	_, err := s.insert.Exec(s.args...)
+	return err
+}
+
+func (s *sqliteSink) Close() error {
+	defer func() { _ = s.db.Close() }()
+	_ = s.insert.Close()
+	if err := s.tx.Commit(); err != nil {
+		return err
+	}
+	logf("INFO", "wrote table %s to %s", s.name, s.path)
+	return nil
+}
+
+// sqliteTableName returns a name for the table of the query which is not already in use,
+// made from the variable names, e.g. city_sex or city_sex_2.
+func sqliteTableName(tx *sql.Tx, variables []string) (string, error) {
+	names := make([]string, 0, len(variables))
+	for _, v := range variables {
+		names = append(names, snakeCase(v))
+	}
+	base := strings.Join(names, "_")
+	name := base
+	for n := 2; ; n++ {
+		var exists int
+		if err := tx.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = ?", name).Scan(&exists); err != nil {
+			return "", err
+		}
+		if exists == 0 {
+			return name, nil
+		}
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// transferStats records connection and transfer timings of a single HTTP request
+// so that slow servers can be told apart from slow networks.
+type transferStats struct {
+	url                        string
+	status                     int
+	start, dnsStart, connStart time.Time
+	tlsStart, firstByte        time.Time
+	dns, connect, tls          time.Duration
+	reused                     bool
+	bytes                      int64
+}
+
+// traceRequest returns req with a client trace which records its timings.
+func (s *transferStats) traceRequest(req *http.Request) *http.Request {
+	s.url = req.URL.Redacted()
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { s.dnsStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { s.dns = time.Since(s.dnsStart) },
+		ConnectStart:         func(string, string) { s.connStart = time.Now() },
+		ConnectDone:          func(string, string, error) { s.connect = time.Since(s.connStart) },
+		TLSHandshakeStart:    func() { s.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { s.tls = time.Since(s.tlsStart) },
+		GotConn:              func(info httptrace.GotConnInfo) { s.reused = info.Reused },
+		GotFirstResponseByte: func() { s.firstByte = time.Now() },
+	}
+	s.start = time.Now()
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// countBody wraps the response body so that the bytes received are counted
+// and the stats are reported when the body is closed.
+func (s *transferStats) countBody(resp *http.Response) {
+	s.status = resp.StatusCode
+	resp.Body = &statsBody{resp.Body, s}
+}
+
+// report writes the stats to stderr as a single line of key=value pairs.
+func (s *transferStats) report() {
+	ttfb := s.firstByte.Sub(s.start)
+	transfer := time.Since(s.firstByte)
+	logf("STATS",
+		"url=%s status=%d reused=%t dns=%s connect=%s tls=%s ttfb=%s transfer=%s bytes=%d rate=%.0fB/s",
+		s.url, s.status, s.reused, s.dns, s.connect, s.tls, ttfb, transfer, s.bytes,
+		float64(s.bytes)/transfer.Seconds())
+}
+
+type statsBody struct {
+	io.ReadCloser
+	stats *transferStats
+}
+
+func (b *statsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.stats.bytes += int64(n)
+	return n, err
+}
+
+func (b *statsBody) Close() error {
+	b.stats.report()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// labelWidths parses -max-label-width, a width for every dimension and/or widths for
+// named variables, e.g. "40" or "40,city=20". A width of 0 means no limit.
+func labelWidths(s string) (all int, byVariable map[string]int) {
+	byVariable = make(map[string]int)
+	for _, part := range strings.Split(s, ",") {
+		name, width, named := strings.Cut(strings.TrimSpace(part), "=")
+		if !named {
+			width = name
+		}
+		n, err := strconv.Atoi(width)
+		if err != nil || n < 0 {
+			panic(usageError("bad -max-label-width %q, expected e.g. 40 or 40,city=20", s))
+		}
+		if named {
+			byVariable[name] = n
+		} else {
+			all = n
+		}
+	}
+	return all, byVariable
+}
+
+// truncateLabels returns the dimensions with category labels longer than the
+// -max-label-width of their variable cut to that many characters, warning of the
+// categories affected so that the loss is not silent.
+func truncateLabels(dims table.Dimensions) table.Dimensions {
+	if *maxLabelWidth == "" {
+		return dims
+	}
+	all, byVariable := labelWidths(*maxLabelWidth)
+	truncated := make(table.Dimensions, len(dims))
+	copy(truncated, dims)
+	for d := range truncated {
+		width, ok := byVariable[dims[d].Variable.Name]
+		if !ok {
+			width = all
+		}
+		if width == 0 {
+			continue
+		}
+		var affected []string
+		cats := dims[d].Categories
+		for i, c := range cats {
+			if r := []rune(c.Label); len(r) > width {
+				if affected == nil {
+					cats = append([]table.Category(nil), cats...)
+				}
+				cats[i].Label = string(r[:width])
+				affected = append(affected, c.Code)
+			}
+		}
+		if affected == nil {
+			continue
+		}
+		truncated[d].Categories = cats
+		const listed = 10
+		codes := strings.Join(affected[:min(len(affected), listed)], ", ")
+		if len(affected) > listed {
+			codes += fmt.Sprintf(" and %d more", len(affected)-listed)
+		}
+		logf("WARNING", "truncated %d labels of %s to %d characters: %s",
+			len(affected), dims[d].Variable.Name, width, codes)
+	}
+	return truncated
+}
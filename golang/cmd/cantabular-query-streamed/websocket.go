@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+	"github.com/gorilla/websocket"
+)
+
+// wsTransport is an http.RoundTripper which sends GraphQL POST requests over a WebSocket
+// using the graphql-ws protocol (subprotocol graphql-transport-ws) rather than HTTP, for
+// deployments which expose the extended API that way. Each request uses a new connection
+// and the response is returned as if it were the body of an HTTP response. The whole
+// response arrives in one message so, unlike over HTTP, it is held in memory.
+type wsTransport struct {
+	dialer websocket.Dialer
+}
+
+// wsMessage is a message of the graphql-ws protocol.
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// newWSTransport returns a wsTransport connecting with the TLS configuration and
+// through the proxy of opts.
+func newWSTransport(opts cantabular.TransportOptions) (*wsTransport, error) {
+	tlsConfig, err := opts.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := opts.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	return &wsTransport{dialer: websocket.Dialer{
+		Subprotocols:    []string{"graphql-transport-ws"},
+		TLSClientConfig: tlsConfig,
+		Proxy:           proxy,
+	}}, nil
+}
+
+// RoundTrip sends the GraphQL request in the body of req as a subscribe message and returns
+// the payload of the first next message as the body of the response.
+func (t *wsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = req.Body.Close()
+
+	u := *req.URL
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	header := req.Header.Clone()
+	header.Del("Content-Type")
+	conn, resp, err := t.dialer.DialContext(req.Context(), u.String(), header)
+	if errors.Is(err, websocket.ErrBadHandshake) {
+		// let the caller deal with the HTTP status, e.g. 401 Unauthorized
+		return resp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := conn.WriteJSON(wsMessage{Type: "connection_init"}); err != nil {
+		return nil, err
+	}
+	if _, err := t.receive(conn, "connection_ack"); err != nil {
+		return nil, err
+	}
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: "subscribe", Payload: body}); err != nil {
+		return nil, err
+	}
+	msg, err := t.receive(conn, "next")
+	if err != nil {
+		return nil, err
+	}
+	// the query has been answered, so tell the server that the operation is finished
+	_ = conn.WriteJSON(wsMessage{ID: "1", Type: "complete"})
+
+	payload := msg.Payload
+	if msg.Type == "error" {
+		// the payload is a list of GraphQL errors
+		payload = append(append([]byte(`{"errors":`), msg.Payload...), '}')
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(payload)),
+		Request:    req,
+	}, nil
+}
+
+// receive reads messages, answering pings, until one of type want is received. When
+// waiting for the next message an error message for the operation is also returned,
+// as it answers the subscribe message. While waiting for the connection_ack any other
+// message is an error, as the connection hasn't been initialised.
+func (t *wsTransport) receive(conn *websocket.Conn, want string) (wsMessage, error) {
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return msg, err
+		}
+		switch {
+		case msg.Type == want, msg.Type == "error" && want == "next":
+			return msg, nil
+		case msg.Type == "ping":
+			if err := conn.WriteJSON(wsMessage{Type: "pong"}); err != nil {
+				return msg, err
+			}
+		case want == "connection_ack":
+			return msg, fmt.Errorf("expected connection_ack but received %s message", msg.Type)
+		case msg.Type == "complete":
+			return msg, fmt.Errorf("operation completed without a result")
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/cantabular/examples/cmd/cantabular-query-streamed/sink"
+	"github.com/cantabular/examples/pkg/value"
+)
+
+func init() {
+	sink.Register("xlsx", func(w io.Writer) sink.Sink { return &xlsxSink{w: w} })
+}
+
+// xlsxSink writes an Excel workbook. The sheet has a bold header row, frozen so that
+// it stays in view, numbers such as the row number and count as numbers, flags as
+// text and Null values as empty cells. Rows are written with a
+// stream writer, which holds them in a temporary file rather than memory.
+type xlsxSink struct {
+	w      io.Writer
+	f      *excelize.File
+	sw     *excelize.StreamWriter
+	values []interface{}
+	row    int
+}
+
+func (s *xlsxSink) Open(meta sink.Metadata) error {
+	const sheet = "Sheet1"
+	s.f = excelize.NewFile()
+	var err error
+	if s.sw, err = s.f.NewStreamWriter(sheet); err != nil {
+		return err
+	}
+	if err := s.sw.SetPanes(&excelize.Panes{
+		Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft",
+	}); err != nil {
+		return err
+	}
+	bold, err := s.f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return err
+	}
+	s.values = make([]interface{}, 0, len(meta.Columns))
+	for _, c := range meta.Columns {
+		s.values = append(s.values, excelize.Cell{StyleID: bold, Value: c.Name})
+	}
+	return s.setRow()
+}
+
+func (s *xlsxSink) WriteRow(row []value.Value) error {
+	s.values = s.values[:0]
+	for _, v := range row {
+		switch v.Kind() {
+		case value.Null:
+			s.values = append(s.values, nil)
+		case value.Int:
+			n, _ := v.Int64()
+			s.values = append(s.values, n)
+		case value.Float:
+			f, _ := v.Float64()
+			s.values = append(s.values, f)
+		default:
+			s.values = append(s.values, v.String())
+		}
+	}
+	return s.setRow()
+}
+
+func (s *xlsxSink) setRow() error {
+	if s.row++; s.row > excelize.TotalRows {
+		return fmt.Errorf("table has more than the %d rows an Excel sheet can hold", excelize.TotalRows-1)
+	}
+	cell, err := excelize.CoordinatesToCellName(1, s.row)
+	if err != nil {
+		return err
+	}
+	return s.sw.SetRow(cell, s.values)
+}
+
+func (s *xlsxSink) Close() error {
+	defer func() { _ = s.f.Close() }()
+	if err := s.sw.Flush(); err != nil {
+		return err
+	}
+	return s.f.Write(s.w)
+}
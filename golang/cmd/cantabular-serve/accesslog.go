@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+// accessRecord is a line of the access log, recording who queried what for the audit trail.
+// Handlers fill in the query and its outcome, see auditRecord.
+type accessRecord struct {
+	Time      time.Time           `json:"time"`
+	Client    string              `json:"client"`
+	User      string              `json:"user,omitempty"` // the authenticated principal, see requireAuth
+	Method    string              `json:"method"`
+	Path      string              `json:"path"`
+	Status    int                 `json:"status"`
+	Job       string              `json:"job,omitempty"`
+	Dataset   string              `json:"dataset,omitempty"`
+	Variables []string            `json:"variables,omitempty"`
+	Filters   []cantabular.Filter `json:"filters,omitempty"`
+	Rows      int64               `json:"rows"`
+	Bytes     int64               `json:"bytes"`
+	Blocked   bool                `json:"blocked"`
+	Error     string              `json:"error,omitempty"`
+	Duration  float64             `json:"duration_seconds"`
+}
+
+// accessLog receives the access log as JSON lines, or is nil if -access-log is not set.
+var (
+	accessLog   io.Writer
+	accessLogMu sync.Mutex
+)
+
+// openAccessLog opens the -access-log destination: - for stderr, syslog, or a file to append to.
+func openAccessLog(dest string) (io.Writer, error) {
+	switch dest {
+	case "":
+		return nil, nil
+	case "-":
+		return os.Stderr, nil
+	case "syslog":
+		return openSyslog()
+	}
+	return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+}
+
+type auditKey struct{}
+
+// auditRecord returns the access record of the request for the handler to fill in.
+// If the access log is disabled then the record is discarded.
+func auditRecord(r *http.Request) *accessRecord {
+	if rec, ok := r.Context().Value(auditKey{}).(*accessRecord); ok {
+		return rec
+	}
+	return &accessRecord{}
+}
+
+// setOutcome records the error of the query, if any, and whether it was blocked.
+func (rec *accessRecord) setOutcome(err error) {
+	if err != nil {
+		rec.Error = err.Error()
+		rec.Blocked = isBlocked(err)
+	}
+}
+
+// logAccess wraps h, writing an access record for each request once it completes if
+// -access-log is set, and counting the usage of tables served.
+func logAccess(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		rec := &accessRecord{Time: time.Now(), Client: client, Method: r.Method, Path: r.URL.Path}
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			rec.Status, rec.Bytes = sw.status, sw.bytes
+			p := recover()
+			if p != nil {
+				// the response was cut short, see handleTable
+				rec.Status = 0
+			}
+			rec.Duration = time.Since(rec.Time).Seconds()
+			served.add(rec)
+			if accessLog != nil {
+				writeAccessRecord(rec)
+			}
+			if p != nil {
+				panic(p)
+			}
+		}()
+		h.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), auditKey{}, rec)))
+	})
+}
+
+func writeAccessRecord(rec *accessRecord) {
+	b, err := json.Marshal(rec)
+	if err == nil {
+		accessLogMu.Lock()
+		_, err = fmt.Fprintf(accessLog, "%s\n", b)
+		accessLogMu.Unlock()
+	}
+	if err != nil {
+		log.Printf("access log: %s", err)
+	}
+}
+
+// statusWriter records the status and size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.status, sw.wroteHeader = code, true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.wroteHeader = true
+	n, err := sw.ResponseWriter.Write(p)
+	sw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter, so that http.ResponseController can
+// flush the response and set its deadlines.
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
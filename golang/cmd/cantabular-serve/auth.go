@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// principal is an authenticated client and what it may query.
+type principal struct {
+	Name     string   `json:"name"`
+	Datasets []string `json:"datasets"` // datasets which may be queried, with wildcards
+	Admin    bool     `json:"admin"`    // whether the /admin endpoints may be used
+}
+
+// mayQuery returns whether the principal may query the dataset. Its datasets may
+// contain wildcards as for path.Match, so "*" grants every dataset. With none it may
+// query no dataset, so that a key or token without datasets does not grant them all.
+func (p *principal) mayQuery(dataset string) bool {
+	for _, d := range p.Datasets {
+		if ok, _ := path.Match(d, dataset); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticator identifies the client making a request. It returns errNoCredentials if
+// the request has no credentials of the kind it checks. A request is accepted if any
+// authenticator accepts it.
+type authenticator interface {
+	authenticate(r *http.Request) (*principal, error)
+}
+
+var errNoCredentials = errors.New("no credentials")
+
+// authenticators are tried in turn for each request. If there are none then
+// requests are not authenticated.
+var authenticators []authenticator
+
+type principalKey struct{}
+
+// requireAuth wraps h, rejecting requests which no authenticator accepts and
+// making the principal available to h, see requestPrincipal. Requests to
+// /admin require an admin principal.
+func requireAuth(h http.Handler) http.Handler {
+	if len(authenticators) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p *principal
+		err := errNoCredentials
+		for _, a := range authenticators {
+			ap, aerr := a.authenticate(r)
+			if aerr == nil {
+				p, err = ap, nil
+				break
+			}
+			if errors.Is(err, errNoCredentials) {
+				err = aerr
+			}
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cantabular-serve"`)
+			http.Error(w, fmt.Sprintf("unauthorized: %s", err), http.StatusUnauthorized)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/admin/") && !p.Admin {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		auditRecord(r).User = p.Name
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// datasetApproved returns whether the dataset matches -allow-datasets, if set, and
+// doesn't match -deny-datasets. Both are comma separated lists of names, which may
+// contain wildcards as for path.Match.
+func datasetApproved(dataset string) bool {
+	matches := func(patterns string) bool {
+		for _, p := range strings.Split(patterns, ",") {
+			if ok, _ := path.Match(strings.TrimSpace(p), dataset); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return (*allowDatasets == "" || matches(*allowDatasets)) && (*denyDatasets == "" || !matches(*denyDatasets))
+}
+
+// mayQuery returns whether the client making the request may query the dataset,
+// which must also be approved by -allow-datasets and -deny-datasets. If it may
+// not then a 403 Forbidden response is written.
+func mayQuery(w http.ResponseWriter, r *http.Request, dataset string) bool {
+	if !datasetApproved(dataset) {
+		http.Error(w, fmt.Sprintf("dataset %q is not approved for querying", dataset), http.StatusForbidden)
+		return false
+	}
+	if p, ok := r.Context().Value(principalKey{}).(*principal); ok && !p.mayQuery(dataset) {
+		http.Error(w, fmt.Sprintf("not permitted to query dataset %q", dataset), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// apiKeys authenticates requests by an API key in the X-API-Key header, or as a
+// bearer token, looking it up in a JSON file mapping keys to principals.
+type apiKeys map[string]*principal
+
+func loadAPIKeys(path string) (apiKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys apiKeys
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
+
+func (keys apiKeys) authenticate(r *http.Request) (*principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = bearerToken(r)
+	}
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	for k, p := range keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return p, nil
+		}
+	}
+	return nil, errors.New("unknown API key")
+}
+
+// jwtHS256 authenticates requests by a JSON Web Token signed with HMAC-SHA256 given as a
+// bearer token. The principal is taken from the sub, datasets and admin claims.
+type jwtHS256 struct{ secret []byte }
+
+func (j jwtHS256) authenticate(r *http.Request) (*principal, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		// not a JWT, perhaps an API key
+		return nil, errNoCredentials
+	}
+	parts := strings.Split(token, ".")
+	var header struct{ Alg string }
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	mac := hmac.New(sha256.New, j.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+	var claims struct {
+		Sub      string
+		Exp, Nbf int64
+		Datasets []string
+		Admin    bool
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	if claims.Exp != 0 && now >= claims.Exp {
+		return nil, errors.New("token expired")
+	}
+	if claims.Nbf != 0 && now < claims.Nbf {
+		return nil, errors.New("token not yet valid")
+	}
+	return &principal{Name: claims.Sub, Datasets: claims.Datasets, Admin: claims.Admin}, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	return nil
+}
+
+// bearerToken returns the token of an Authorization: Bearer header, if any.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
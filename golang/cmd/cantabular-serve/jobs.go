@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+	"github.com/cantabular/examples/pkg/httpconvert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Job statuses
+const (
+	statusQueued    = "queued"
+	statusRunning   = "running"
+	statusDone      = "done"
+	statusFailed    = "failed"
+	statusCancelled = "cancelled"
+)
+
+// jobsBucket is the bolt bucket in which jobs are stored as JSON keyed by ID.
+var jobsBucket = []byte("jobs")
+
+// job is an extract run asynchronously. The mutex of the jobServer guards its fields,
+// apart from rows which counts the rows written as the job progresses, and is only
+// copied to Rows in a snapshot, so that the job may be encoded while it runs.
+type job struct {
+	ID        string              `json:"id"`
+	Dataset   string              `json:"dataset"`
+	Variables []string            `json:"variables"`
+	Filters   []cantabular.Filter `json:"filters,omitempty"`
+	Status    string              `json:"status"`
+	Error     string              `json:"error,omitempty"`
+	Blocked   bool                `json:"blocked,omitempty"`
+	Rows      int64               `json:"rows"`
+	Attempts  int                 `json:"attempts"`
+	Created   time.Time           `json:"created"`
+	Started   *time.Time          `json:"started,omitempty"`
+	Finished  *time.Time          `json:"finished,omitempty"`
+
+	cancel context.CancelFunc // cancels the job while it is running
+	rows   atomic.Int64
+}
+
+// snapshot returns a copy of the job's fields, with the rows written so far, to be
+// encoded. The caller must hold the mutex.
+func (j *job) snapshot() *job {
+	return &job{
+		ID:        j.ID,
+		Dataset:   j.Dataset,
+		Variables: j.Variables,
+		Filters:   j.Filters,
+		Status:    j.Status,
+		Error:     j.Error,
+		Blocked:   j.Blocked,
+		Rows:      j.rows.Load(),
+		Attempts:  j.Attempts,
+		Created:   j.Created,
+		Started:   j.Started,
+		Finished:  j.Finished,
+	}
+}
+
+// jobServer serves the /jobs API, running queued jobs with a fixed number of workers
+// and storing their results in files in dir. Jobs are recorded in a bolt database in
+// dir so that those queued or running when the server stops are run when it restarts.
+type jobServer struct {
+	mu         sync.Mutex
+	jobs       map[string]*job
+	queue      chan *job
+	dir        string
+	db         *bolt.DB
+	retries    int
+	retryDelay time.Duration
+}
+
+func newJobServer(workers, queueSize int, dir string, retries int, retryDelay time.Duration) (*jobServer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(filepath.Join(dir, "jobs.db"), 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening job store: %w", err)
+	}
+	js := &jobServer{
+		jobs:       make(map[string]*job),
+		queue:      make(chan *job, queueSize),
+		dir:        dir,
+		db:         db,
+		retries:    retries,
+		retryDelay: retryDelay,
+	}
+	pending, err := js.load()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < workers; i++ {
+		go js.work()
+	}
+	go func() {
+		for _, j := range pending {
+			js.queue <- j
+		}
+	}()
+	return js, nil
+}
+
+// load reads the stored jobs returning, oldest first, those which are still to be run.
+// Jobs which were running when the server stopped are run again.
+func (js *jobServer) load() (pending []*job, err error) {
+	err = js.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(jobsBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(_, v []byte) error {
+			j := new(job)
+			if err := json.Unmarshal(v, j); err != nil {
+				return err
+			}
+			j.rows.Store(j.Rows)
+			js.jobs[j.ID] = j
+			if j.Status == statusQueued || j.Status == statusRunning {
+				j.Status = statusQueued
+				pending = append(pending, j)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading job store: %w", err)
+	}
+	sort.Slice(pending, func(i, k int) bool { return pending[i].Created.Before(pending[k].Created) })
+	if len(pending) > 0 {
+		log.Printf("Resuming %d jobs", len(pending))
+	}
+	return pending, nil
+}
+
+// save records the job in the store. The caller must hold the mutex.
+func (js *jobServer) save(j *job) {
+	v, err := json.Marshal(j.snapshot())
+	if err == nil {
+		err = js.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(jobsBucket).Put([]byte(j.ID), v)
+		})
+	}
+	if err != nil {
+		log.Printf("job %s: saving: %s", j.ID, err)
+	}
+}
+
+// ServeHTTP routes POST /jobs, GET /jobs/<id> and GET /jobs/<id>/result.
+func (js *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+	switch parts := strings.Split(path, "/"); {
+	case r.URL.Path == "/jobs" && r.Method == http.MethodPost:
+		js.create(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		js.status(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet:
+		js.result(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// create queues the job described by the request body.
+func (js *jobServer) create(w http.ResponseWriter, r *http.Request) {
+	var spec struct {
+		Dataset   string
+		Variables []string
+		Filters   []cantabular.Filter
+	}
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, fmt.Sprintf("invalid job: %s", err), http.StatusBadRequest)
+		return
+	}
+	if spec.Dataset == "" || len(spec.Variables) == 0 {
+		http.Error(w, "invalid job: dataset and variables are required", http.StatusBadRequest)
+		return
+	}
+	if !mayQuery(w, r, spec.Dataset) {
+		return
+	}
+	j := &job{
+		ID:        newJobID(),
+		Dataset:   spec.Dataset,
+		Variables: spec.Variables,
+		Filters:   spec.Filters,
+		Status:    statusQueued,
+		Created:   time.Now(),
+	}
+	js.mu.Lock()
+	select {
+	case js.queue <- j:
+	default:
+		js.mu.Unlock()
+		http.Error(w, "too many queued jobs", http.StatusServiceUnavailable)
+		return
+	}
+	js.jobs[j.ID] = j
+	js.save(j)
+	js.mu.Unlock()
+	audit := auditRecord(r)
+	audit.Job, audit.Dataset, audit.Variables, audit.Filters = j.ID, j.Dataset, j.Variables, j.Filters
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	js.writeStatus(w, j, http.StatusAccepted)
+}
+
+// status returns the status of the job.
+func (js *jobServer) status(w http.ResponseWriter, r *http.Request, id string) {
+	j := js.job(id)
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	if mayQuery(w, r, j.Dataset) {
+		js.writeStatus(w, j, http.StatusOK)
+	}
+}
+
+// result downloads the CSV of a job which is done.
+func (js *jobServer) result(w http.ResponseWriter, r *http.Request, id string) {
+	j := js.job(id)
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	if !mayQuery(w, r, j.Dataset) {
+		return
+	}
+	js.mu.Lock()
+	status, errMsg := j.Status, j.Error
+	audit := auditRecord(r)
+	audit.Job, audit.Dataset, audit.Variables, audit.Filters = j.ID, j.Dataset, j.Variables, j.Filters
+	audit.Rows, audit.Blocked = j.rows.Load(), j.Blocked
+	js.mu.Unlock()
+	switch status {
+	case statusDone:
+	case statusFailed:
+		http.Error(w, fmt.Sprintf("job failed: %s", errMsg), http.StatusConflict)
+		return
+	default:
+		http.Error(w, fmt.Sprintf("job is %s", status), http.StatusConflict)
+		return
+	}
+	f, err := os.Open(js.resultPath(id))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = f.Close() }()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", id+".csv"))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+func (js *jobServer) job(id string) *job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	return js.jobs[id]
+}
+
+func (js *jobServer) writeStatus(w http.ResponseWriter, j *job, code int) {
+	js.mu.Lock()
+	snapshot := j.snapshot()
+	js.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(snapshot)
+}
+
+func (js *jobServer) resultPath(id string) string {
+	return filepath.Join(js.dir, id+".csv")
+}
+
+// work runs jobs from the queue until the program exits.
+func (js *jobServer) work() {
+	for j := range js.queue {
+		ctx, cancel := context.WithCancel(context.Background())
+		js.mu.Lock()
+		if j.Status != statusQueued {
+			// cancelled while waiting
+			js.mu.Unlock()
+			cancel()
+			continue
+		}
+		now := time.Now()
+		j.Status, j.Started, j.cancel = statusRunning, &now, cancel
+		j.Attempts++
+		j.rows.Store(0)
+		js.save(j)
+		js.mu.Unlock()
+
+		err := js.run(ctx, j)
+		cancel()
+		js.finish(j, err)
+	}
+}
+
+// run runs the job, writing the result to a temporary file which is renamed on success.
+func (js *jobServer) run(ctx context.Context, j *job) error {
+	f, err := os.CreateTemp(js.dir, ".tmp-"+j.ID+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	err = queryTable(ctx, j.Dataset, j.Variables, j.Filters, httpconvert.CSV, f, func() {
+		j.rows.Add(1)
+	})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), js.resultPath(j.ID))
+}
+
+// finish records the outcome of running the job. Jobs which failed for reasons other than
+// the query itself are queued again after a delay which doubles with each attempt, until
+// they have been retried the number of times allowed.
+func (js *jobServer) finish(j *job, err error) {
+	now := time.Now()
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j.cancel = nil
+	switch {
+	case j.Status == statusCancelled:
+		_ = os.Remove(js.resultPath(j.ID))
+		return
+	case err == nil:
+		j.Status, j.Error, j.Finished = statusDone, "", &now
+	case !isQueryError(err) && j.Attempts <= js.retries:
+		delay := js.retryDelay << (j.Attempts - 1)
+		log.Printf("job %s failed, retrying in %s: %s", j.ID, delay, err)
+		j.Status, j.Error = statusQueued, err.Error()
+		time.AfterFunc(delay, func() { js.requeue(j) })
+	default:
+		log.Printf("job %s failed: %s", j.ID, err)
+		j.Status, j.Error, j.Finished = statusFailed, err.Error(), &now
+		j.Blocked = isBlocked(err)
+	}
+	js.save(j)
+}
+
+// requeue queues a job again to retry it, unless it was cancelled while waiting. As
+// for a new job, the queue is not allowed to grow beyond its bound, so if it is full
+// the job fails instead.
+func (js *jobServer) requeue(j *job) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if j.Status != statusQueued {
+		return
+	}
+	select {
+	case js.queue <- j:
+	default:
+		now := time.Now()
+		log.Printf("job %s failed, as too many jobs are queued to retry it", j.ID)
+		j.Status, j.Finished = statusFailed, &now
+		j.Error += " (not retried as too many jobs are queued)"
+		js.save(j)
+	}
+}
+
+// serveAdmin serves GET /admin/jobs, listing all jobs, and POST /admin/jobs/<id>/cancel.
+func (js *jobServer) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/jobs"), "/")
+	switch parts := strings.Split(path, "/"); {
+	case path == "" && r.Method == http.MethodGet:
+		js.mu.Lock()
+		list := make([]*job, 0, len(js.jobs))
+		for _, j := range js.jobs {
+			list = append(list, j.snapshot())
+		}
+		js.mu.Unlock()
+		sort.Slice(list, func(i, k int) bool { return list[i].Created.Before(list[k].Created) })
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	case len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		js.cancel(w, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// cancel cancels a job which is queued or running.
+func (js *jobServer) cancel(w http.ResponseWriter, id string) {
+	j := js.job(id)
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	js.mu.Lock()
+	if j.Status != statusQueued && j.Status != statusRunning {
+		status := j.Status
+		js.mu.Unlock()
+		http.Error(w, fmt.Sprintf("job is %s", status), http.StatusConflict)
+		return
+	}
+	now := time.Now()
+	j.Status, j.Finished = statusCancelled, &now
+	if j.cancel != nil {
+		j.cancel()
+	}
+	js.save(j)
+	js.mu.Unlock()
+	js.writeStatus(w, j, http.StatusOK)
+}
+
+func newJobID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// limitError is returned when a query exceeds one of the configured limits.
+type limitError struct{ msg string }
+
+func (e *limitError) Error() string { return e.msg }
+
+// upstreamSlots limits the number of queries made to the extended API at once, from
+// both /table and jobs, if -max-upstream is set.
+var upstreamSlots chan struct{}
+
+// acquireUpstream waits for a free slot to query the extended API, returning a function
+// to release it.
+func acquireUpstream(ctx context.Context) (release func(), err error) {
+	if upstreamSlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case upstreamSlots <- struct{}{}:
+		return func() { <-upstreamSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// checkCells returns a limitError if the table has more cells than -max-cells.
+func checkCells(cells int) error {
+	if *maxCells > 0 && cells > *maxCells {
+		return &limitError{fmt.Sprintf("table has %d cells, more than the limit of %d", cells, *maxCells)}
+	}
+	return nil
+}
+
+// clientLimiter limits the rate of requests from each client address using a token bucket,
+// allowing bursts of up to burst requests.
+type clientLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+	pruned  time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newClientLimiter returns a limiter allowing perMinute requests a minute from each client.
+func newClientLimiter(perMinute int) *clientLimiter {
+	return &clientLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   math.Max(1, float64(perMinute)/6),
+		buckets: make(map[string]*bucket),
+		pruned:  time.Now(),
+	}
+}
+
+// limit wraps h, responding 429 Too Many Requests to clients which exceed their rate.
+func (l *clientLimiter) limit(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if wait := l.reserve(client, time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// reserve takes a token for the client, returning zero if one was available or else
+// how long until there will be one.
+func (l *clientLimiter) reserve(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.pruned) > time.Minute {
+		// forget clients whose buckets have refilled
+		for c, b := range l.buckets {
+			if now.Sub(b.last).Seconds()*l.rate+b.tokens >= l.burst {
+				delete(l.buckets, c)
+			}
+		}
+		l.pruned = now
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
@@ -0,0 +1,233 @@
+// Copyright 2026 The Sensible Code Company Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// For function see description of main() method.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+var (
+	apiUrl = flag.String("u", "http://localhost:8492/graphql",
+		"Extended API URL")
+	listen = flag.String("listen", "localhost:8080",
+		"Address to listen on")
+	upstreamTimeout = flag.Duration("upstream-timeout", 0,
+		"Maximum time a query to the extended API may take (0 for no limit)")
+	maxCells = flag.Int("max-cells", 0,
+		"Refuse tables with more than this many cells (0 for no limit)")
+	maxUpstream = flag.Int("max-upstream", 0,
+		"Maximum number of queries made to the extended API at once; others wait (0 for no limit)")
+	clientRate = flag.Int("client-rate", 0,
+		"Maximum number of queries a minute from each client address (0 for no limit)")
+	accessLogDest = flag.String("access-log", "",
+		"Write a JSON access log recording each query and its outcome to this file, - for stderr, or syslog")
+	allowDatasets = flag.String("allow-datasets", "",
+		"Only allow queries of these comma separated datasets, which may contain wildcards such as *")
+	denyDatasets = flag.String("deny-datasets", "",
+		"Refuse queries of these comma separated datasets, which may contain wildcards such as *")
+	usageReport = flag.Duration("usage-report", 0,
+		"Log the cells and bytes served by dataset and client at this interval (0 disables)")
+	apiKeysPath = flag.String("api-keys", "",
+		`Require an API key from this JSON file, e.g. {"<key>": {"name": "dashboard", "datasets": ["<dataset-name>"], "admin": false}}`+"\n"+
+			`in which datasets may contain wildcards such as *, e.g. ["*"] to allow every dataset`)
+	jwtSecretPath = flag.String("jwt-secret", "",
+		"Require a JWT signed with HS256 using the secret in this file, with sub, datasets and admin claims,\n"+
+			"in which datasets are as for -api-keys")
+	jobs = flag.Bool("jobs", false,
+		"Enable the /jobs API for running extracts asynchronously")
+	workers = flag.Int("workers", 4,
+		"Number of jobs run at once")
+	queueSize = flag.Int("queue", 100,
+		"Number of jobs which may wait to run before new jobs are refused")
+	jobDir = flag.String("job-dir", filepath.Join(os.TempDir(), "cantabular-jobs"),
+		"Directory in which jobs and their results are stored")
+	retries = flag.Int("retries", 2,
+		"Number of times a job which failed for reasons other than the query is retried")
+	retryDelay = flag.Duration("retry-delay", 30*time.Second,
+		"Delay before the first retry of a job, doubling with each further retry")
+	drainTimeout = flag.Duration("drain-timeout", 30*time.Second,
+		"On SIGINT or SIGTERM, how long to wait for requests in progress before dropping them")
+	writeTimeout = flag.Duration("write-timeout", time.Minute,
+		"Fail a table if the client accepts none of it for this long, releasing its query of the extended API (0 for no limit)")
+	clientBuffer = flag.Int("client-buffer", 64<<10,
+		"Bytes of a table buffered for each client, beyond which the table waits for the client to accept them")
+	flushInterval = flag.Duration("flush-interval", time.Second,
+		"Send the part of a table buffered for a client at least this often (0 to send it only when the buffer is full)")
+	installSvc = flag.Bool("install-service", false,
+		"On Windows, install a service running the server with the other options given, and exit")
+	uninstallSvc = flag.Bool("uninstall-service", false,
+		"On Windows, uninstall the service, and exit")
+	serviceName = flag.String("service-name", "cantabular-serve",
+		"Name of the Windows service, and of its event log source")
+)
+
+func init() {
+	const usage = `Usage: %s [options]
+
+Serves tables from the extended API as CSV over HTTP:
+
+  GET /table?dataset=<dataset-name>&variables=<var>,<var>...[&filter=<var>=<code>,<code>...]
+      Queries the table and streams it as it is received, as CSV unless the Accept
+      header prefers application/json, application/x-ndjson or
+      application/vnd.apache.arrow.stream.
+  GET /admin/metrics
+      Returns the tables, cells and bytes served by dataset and client for Prometheus.
+  GET /health
+      Returns 200 while accepting requests and 503 once shutting down, without
+      authentication.
+
+With -jobs, extracts can also be run asynchronously:
+
+  POST /jobs                {"dataset": "<dataset-name>", "variables": ["<var>", ...],
+                             "filters": [{"variable": "<var>", "codes": ["<code>", ...]}, ...]}
+      Queues a job and returns its status, with its URL in the Location header.
+  GET  /jobs/<id>           Returns the status and progress of the job.
+  GET  /jobs/<id>/result    Downloads the CSV once the job is done.
+
+  GET  /admin/jobs                 Lists all jobs.
+  POST /admin/jobs/<id>/cancel     Cancels a job which is queued or running.
+
+Jobs are kept in -job-dir and those which had not finished are resumed on restart.
+
+With -api-keys or -jwt-secret, requests must be authenticated with an X-API-Key or
+Authorization: Bearer header, and may only query the datasets allowed for the key or
+token. The /admin endpoints require an admin key or token.
+
+Under systemd, the server notifies readiness once listening, so may be run as a
+service of Type=notify. On Windows, -install-service installs it as a service
+with the options given, which should use absolute paths, and logs to the event log.
+
+Options:
+`
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+// This example demonstrates how the streaming conversion of tables may be offered
+// as a web service, which is the starting point for teams wrapping these examples
+// in web applications. See usage above or run program for help.
+func main() {
+	if flag.Parse(); len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	switch {
+	case *installSvc:
+		if err := installService(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	case *uninstallSvc:
+		if err := uninstallService(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// the handlers share the client, and so its connections to the API
+	transport, err := cantabular.TransportOptions{IdleConns: *maxUpstream}.Transport()
+	if err != nil {
+		log.Fatal(err)
+	}
+	client = cantabular.NewClient(*apiUrl, cantabular.WithHTTPClient(&http.Client{Transport: transport}))
+	if accessLog, err = openAccessLog(*accessLogDest); err != nil {
+		log.Fatal(err)
+	}
+	if *jwtSecretPath != "" {
+		secret, err := os.ReadFile(*jwtSecretPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authenticators = append(authenticators, jwtHS256{bytes.TrimSpace(secret)})
+	}
+	if *apiKeysPath != "" {
+		keys, err := loadAPIKeys(*apiKeysPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if *maxUpstream > 0 {
+		upstreamSlots = make(chan struct{}, *maxUpstream)
+	}
+	limit := func(h http.Handler) http.Handler { return h }
+	if *clientRate > 0 {
+		limit = newClientLimiter(*clientRate).limit
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/table", limit(http.HandlerFunc(handleTable)))
+	mux.HandleFunc("/admin/metrics", served.serveMetrics)
+	if *usageReport > 0 {
+		go served.report(*usageReport)
+	}
+	if *jobs {
+		js, err := newJobServer(*workers, *queueSize, *jobDir, *retries, *retryDelay)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mux.Handle("/jobs", limit(js))
+		mux.Handle("/jobs/", js)
+		mux.HandleFunc("/admin/jobs", js.serveAdmin)
+		mux.HandleFunc("/admin/jobs/", js.serveAdmin)
+	}
+
+	var active inFlight
+	root := http.NewServeMux()
+	root.HandleFunc("/health", serveHealth) // neither authenticated, logged nor counted
+	root.Handle("/", active.track(logAccess(requireAuth(mux))))
+	srv := &http.Server{Handler: root}
+	run := func(ctx context.Context, ready func()) error { return serve(ctx, srv, &active, ready) }
+	if ok, err := runAsService(run); ok || err != nil {
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, func() {}); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// inFlight counts the requests in progress.
+type inFlight struct{ n int64 }
+
+func (f *inFlight) track(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&f.n, 1)
+		defer atomic.AddInt64(&f.n, -1)
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (f *inFlight) count() int64 { return atomic.LoadInt64(&f.n) }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+	"github.com/cantabular/examples/pkg/httpconvert"
+)
+
+// client queries the extended API at -u.
+var client *cantabular.Client
+
+// isQueryError returns whether err was reported by the extended API about the query
+// itself, or the query exceeded a limit, as opposed to a failure to obtain a response.
+func isQueryError(err error) bool {
+	return errors.As(err, new(*cantabular.GraphQLError)) || errors.As(err, new(*cantabular.TableError)) ||
+		errors.As(err, new(*limitError))
+}
+
+// isBlocked returns whether the table was blocked by the extended API, for example by
+// disclosure control rules.
+func isBlocked(err error) bool {
+	return errors.As(err, new(*cantabular.TableError))
+}
+
+// queryTable queries the table restricted by filters and writes it to w in format, calling onRow
+// after each row. The query is abandoned if ctx is cancelled or it takes longer than -upstream-timeout.
+func queryTable(ctx context.Context, dataset string, vars []string, filters []cantabular.Filter,
+	format httpconvert.Format, w io.Writer, onRow func()) error {
+	release, err := acquireUpstream(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if *upstreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *upstreamTimeout)
+		defer cancel()
+	}
+	body, err := client.QueryStream(ctx, dataset, vars, filters)
+	if err != nil {
+		return fmt.Errorf("extended API: %w", err)
+	}
+	defer func() { _ = body.Close() }()
+	return httpconvert.Convert(body, w, httpconvert.Options{OnRow: onRow, CheckCells: checkCells, Format: format})
+}
+
+// handleTable serves GET /table by streaming the table as it is received, as CSV or
+// another of the formats of httpconvert as negotiated by the Accept header, so that
+// the same URL serves every kind of client. The table is given by the parameters of
+// the request as for httpconvert.Handler, see httpconvert.ParseQuery.
+func handleTable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := httpconvert.ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	format, ok := httpconvert.Negotiate(r.Header.Get("Accept"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("none of the formats %v are acceptable", httpconvert.Formats), http.StatusNotAcceptable)
+		return
+	}
+	audit := auditRecord(r)
+	audit.Dataset, audit.Variables, audit.Filters = q.Dataset, q.Variables, q.Filters
+	if !mayQuery(w, r, q.Dataset) {
+		return
+	}
+
+	cw := newClientWriter(w)
+	ww := &httpconvert.WatchedWriter{Writer: cw}
+	w.Header().Set("Content-Type", format.ContentType())
+	err = queryTable(r.Context(), q.Dataset, q.Variables, q.Filters, format, ww, func() { audit.Rows++ })
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+	audit.setOutcome(err)
+	switch {
+	case err == nil:
+	case ww.Written:
+		// the status has been sent so all that can be done is to cut the response short
+		log.Printf("%s: %s", r.URL, err)
+		panic(http.ErrAbortHandler)
+	case isQueryError(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, fmt.Sprintf("extended API did not respond within %s", *upstreamTimeout), http.StatusGatewayTimeout)
+	default:
+		log.Printf("%s: %s", r.URL, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// draining is set once shutdown has begun, so that /health reports the server as
+// unavailable and load balancers stop sending it requests while it drains.
+var draining atomic.Bool
+
+// serveHealth reports whether the server is accepting requests, for load balancers
+// and service managers. It doesn't query the extended API, so that an outage of the
+// API doesn't cause every server in front of it to be restarted.
+func serveHealth(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("shutting down\n"))
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// sdNotify sends state, such as READY=1, to systemd if it started the server as a
+// service of Type=notify, as given by $NOTIFY_SOCKET. Otherwise it does nothing.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// a name starting with @ is of an abstract socket, which net handles
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		log.Printf("Notifying systemd: %s", err)
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Notifying systemd: %s", err)
+	}
+}
+
+// serve serves srv on -listen until ctx is done, calling ready once it is listening,
+// then stops accepting connections and waits for requests in progress, such as
+// tables being streamed, to finish. Jobs which are queued or running are resumed
+// when the server next starts.
+func serve(ctx context.Context, srv *http.Server, active *inFlight, ready func()) error {
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	log.Printf("Listening on %s", ln.Addr())
+	sdNotify("READY=1")
+	ready()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	draining.Store(true)
+	sdNotify("STOPPING=1")
+	log.Printf("Shutting down, waiting up to %s for %d requests in progress", *drainTimeout, active.count())
+	drainCtx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		log.Printf("Dropping %d requests still in progress: %s", active.count(), err)
+		_ = srv.Close()
+	}
+	log.Print("Shut down")
+	return nil
+}
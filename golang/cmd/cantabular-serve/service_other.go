@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var errNotWindows = errors.New("Windows services are not available on this platform; use systemd or another service manager")
+
+func installService() error { return errNotWindows }
+
+func uninstallService() error { return errNotWindows }
+
+// runAsService returns false, as the server only runs as a Windows service on Windows.
+func runAsService(func(ctx context.Context, ready func()) error) (bool, error) {
+	return false, nil
+}
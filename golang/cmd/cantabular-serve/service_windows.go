@@ -0,0 +1,133 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installService installs the server as a Windows service named -service-name which
+// starts automatically, with the options of this command line, and registers it as
+// a source of the event log to which it logs.
+func installService() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "install-service" && f.Name != "uninstall-service" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = m.Disconnect() }()
+	s, err := m.CreateService(*serviceName, exe, mgr.Config{
+		DisplayName: "Cantabular table server",
+		Description: "Serves tables from the Cantabular extended API as CSV over HTTP",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.Close() }()
+	if err := eventlog.InstallAsEventCreate(*serviceName, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		_ = s.Delete()
+		return err
+	}
+	log.Printf("Installed service %s: %s %s", *serviceName, exe, strings.Join(args, " "))
+	return nil
+}
+
+// uninstallService removes the service named -service-name and its event log source.
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = m.Disconnect() }()
+	s, err := m.OpenService(*serviceName)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = s.Close() }()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	if err := eventlog.Remove(*serviceName); err != nil {
+		log.Printf("Removing event log source: %s", err)
+	}
+	log.Printf("Uninstalled service %s", *serviceName)
+	return nil
+}
+
+// runAsService runs serve as a Windows service if the service manager started the
+// server, returning false otherwise. serve's context is done when the service is
+// stopped or Windows shuts down, and the service is reported running once ready is
+// called. The log is written to the event log.
+func runAsService(serve func(ctx context.Context, ready func()) error) (bool, error) {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return false, err
+	}
+	if elog, err := eventlog.Open(*serviceName); err == nil {
+		defer func() { _ = elog.Close() }()
+		log.SetFlags(0)
+		log.SetOutput(eventLogWriter{elog})
+	}
+	return true, svc.Run(*serviceName, &service{serve: serve})
+}
+
+type service struct {
+	serve func(ctx context.Context, ready func()) error
+}
+
+func (s *service) Execute(_ []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.serve(ctx, func() { changes <- svc.Status{State: svc.Running, Accepts: accepts} })
+	}()
+	for {
+		select {
+		case err := <-errc:
+			if err != nil {
+				log.Print(err)
+				return false, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				changes <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				changes <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+// eventLogWriter writes each line of the log as an information event.
+type eventLogWriter struct{ elog *eventlog.Log }
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	if err := w.elog.Info(1, strings.TrimSuffix(string(p), "\n")); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// clientWriter writes a table to a client, buffering up to -client-buffer bytes and
+// flushing them at least every -flush-interval, so that the rows of slow queries reach
+// the client promptly without holding more than the buffer for a client which reads
+// slowly. Each write must complete within -write-timeout, so that a client which stops
+// reading fails the request, releasing its query of the extended API, rather than
+// blocking it indefinitely.
+type clientWriter struct {
+	mu   sync.Mutex
+	rc   *http.ResponseController
+	bw   *bufio.Writer
+	done chan struct{}
+}
+
+// newClientWriter returns a clientWriter for w, which must be closed once the table
+// has been written.
+func newClientWriter(w http.ResponseWriter) *clientWriter {
+	rc := http.NewResponseController(w)
+	cw := &clientWriter{rc: rc, bw: bufio.NewWriterSize(&deadlineWriter{w: w, rc: rc}, *clientBuffer),
+		done: make(chan struct{})}
+	if *flushInterval > 0 {
+		go cw.flushEvery(*flushInterval)
+	}
+	return cw
+}
+
+func (cw *clientWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	return cw.bw.Write(p)
+}
+
+// flushEvery flushes the buffer at each interval until the writer is closed.
+func (cw *clientWriter) flushEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			cw.mu.Lock()
+			if cw.bw.Buffered() > 0 {
+				_ = cw.bw.Flush() // the error is sticky, so is returned by the next write
+			}
+			cw.mu.Unlock()
+		case <-cw.done:
+			return
+		}
+	}
+}
+
+// Close flushes the rest of the table and clears the write deadline, so that it
+// doesn't apply to the next request on the connection.
+func (cw *clientWriter) Close() error {
+	close(cw.done)
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	err := cw.bw.Flush()
+	if derr := cw.rc.SetWriteDeadline(time.Time{}); err == nil && !errors.Is(derr, http.ErrNotSupported) {
+		err = derr
+	}
+	return err
+}
+
+// deadlineWriter writes to the client within -write-timeout, flushing each write to
+// the connection.
+type deadlineWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	if *writeTimeout > 0 {
+		if err := dw.rc.SetWriteDeadline(time.Now().Add(*writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return 0, err
+		}
+	}
+	n, err := dw.w.Write(p)
+	if err == nil {
+		if err = dw.rc.Flush(); errors.Is(err, http.ErrNotSupported) {
+			err = nil
+		}
+	}
+	return n, err
+}
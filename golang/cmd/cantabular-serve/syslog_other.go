@@ -0,0 +1,12 @@
+//go:build windows || plan9 || js || wasip1
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog is not available on this platform")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// usageKey identifies whose use of which dataset is counted.
+type usageKey struct{ dataset, client string }
+
+// usageCounts are the totals served for a usageKey.
+type usageCounts struct{ queries, cells, bytes int64 }
+
+// usageCounters count the tables served by dataset and client, so that data owners can
+// see which tables are used. The client is the authenticated name if there is one, or
+// else the client address.
+type usageCounters struct {
+	mu     sync.Mutex
+	counts map[usageKey]*usageCounts
+}
+
+var served = &usageCounters{counts: make(map[usageKey]*usageCounts)}
+
+// add counts a request which served a table, i.e. from /table or a job result.
+func (u *usageCounters) add(rec *accessRecord) {
+	if rec.Dataset == "" || rec.Method != http.MethodGet || rec.Status != http.StatusOK {
+		return
+	}
+	k := usageKey{rec.Dataset, rec.User}
+	if k.client == "" {
+		k.client = rec.Client
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	c := u.counts[k]
+	if c == nil {
+		c = &usageCounts{}
+		u.counts[k] = c
+	}
+	c.queries++
+	c.cells += rec.Rows
+	c.bytes += rec.Bytes
+}
+
+// snapshot returns the keys in order and a copy of their counts.
+func (u *usageCounters) snapshot() ([]usageKey, map[usageKey]usageCounts) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	keys := make([]usageKey, 0, len(u.counts))
+	counts := make(map[usageKey]usageCounts, len(u.counts))
+	for k, c := range u.counts {
+		keys = append(keys, k)
+		counts[k] = *c
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].dataset != keys[j].dataset {
+			return keys[i].dataset < keys[j].dataset
+		}
+		return keys[i].client < keys[j].client
+	})
+	return keys, counts
+}
+
+// serveMetrics serves the counters in the Prometheus text exposition format.
+func (u *usageCounters) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	keys, counts := u.snapshot()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range []struct {
+		name, help string
+		value      func(c usageCounts) int64
+	}{
+		{"cantabular_serve_queries_total", "Tables served.", func(c usageCounts) int64 { return c.queries }},
+		{"cantabular_serve_cells_total", "Table cells served.", func(c usageCounts) int64 { return c.cells }},
+		{"cantabular_serve_bytes_total", "Bytes of tables served.", func(c usageCounts) int64 { return c.bytes }},
+	} {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(w, "%s{dataset=%s,client=%s} %d\n", m.name,
+				labelValue(k.dataset), labelValue(k.client), m.value(counts[k]))
+		}
+	}
+}
+
+// labelValue quotes a Prometheus label value.
+func labelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// report logs the counters every interval until the program exits.
+func (u *usageCounters) report(interval time.Duration) {
+	for range time.Tick(interval) {
+		keys, counts := u.snapshot()
+		for _, k := range keys {
+			c := counts[k]
+			log.Printf("Usage: dataset=%q client=%q queries=%d cells=%d bytes=%d",
+				k.dataset, k.client, c.queries, c.cells, c.bytes)
+		}
+	}
+}
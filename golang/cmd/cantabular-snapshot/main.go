@@ -0,0 +1,182 @@
+// Copyright 2026 The Sensible Code Company Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+//
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+// For function see description of main() method.
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+)
+
+var (
+	apiUrl = flag.String("u", "http://localhost:8492/graphql",
+		"Extended API URL")
+	outputDir = flag.String("o", "",
+		"Directory to write the snapshot to (default the dataset name)")
+	tables = flag.String("tables", "all",
+		"Variables to export univariate tables of: all, none, or a comma-separated list of names")
+	parallel = flag.Int("parallel", 4,
+		"Number of variables to request the categories of at once")
+)
+
+func init() {
+	const usage = `Usage: %s [options] <dataset>
+
+Exports a snapshot of the public shape of a dataset to a directory: its codebook,
+every variable with its categories, as codebook.json and codebook.csv, and the
+univariate table of each of the -tables variables as tables/<variable>.json and
+tables/<variable>.csv. Tables blocked by disclosure control are reported and left
+out of the snapshot.
+
+Options:
+`
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), usage, filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+}
+
+// Codebook describes the variables of a dataset
+type Codebook struct {
+	Dataset   string                          `json:"dataset"`
+	Variables []cantabular.VariableCategories `json:"variables"`
+}
+
+// This example demonstrates exporting the codebook and univariate tables of a dataset,
+// giving a local copy which may be versioned. See usage above or run program for help.
+func main() {
+	if flag.Parse(); len(flag.Args()) != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	dataset := flag.Arg(0)
+	dir := *outputDir
+	if dir == "" {
+		dir = dataset
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "tables"), 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client := cantabular.NewClient(*apiUrl)
+	codebook, err := queryCodebook(ctx, client, dataset)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeJSON(filepath.Join(dir, "codebook.json"), codebook); err != nil {
+		log.Fatal(err)
+	}
+	if err := writeCodebookCSV(filepath.Join(dir, "codebook.csv"), codebook); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("exported codebook of %d variables", len(codebook.Variables))
+
+	for _, name := range tableVariables(codebook) {
+		table, err := client.Query(ctx, dataset, []string{name}, nil)
+		var blocked *cantabular.TableError
+		if errors.As(err, &blocked) {
+			log.Printf("%s: %s", name, err)
+			continue
+		} else if err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+		base := filepath.Join(dir, "tables", name)
+		if err := writeJSON(base+".json", table); err != nil {
+			log.Fatal(err)
+		}
+		f, err := os.Create(base + ".csv")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := table.WriteCSV(f); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("exported table of %s", name)
+	}
+}
+
+// queryCodebook returns the codebook of the dataset, requesting the categories of
+// -parallel variables at once.
+func queryCodebook(ctx context.Context, client *cantabular.Client, dataset string) (*Codebook, error) {
+	names, err := client.VariableNames(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := client.Categories(ctx, dataset, names, *parallel)
+	if err != nil {
+		return nil, err
+	}
+	return &Codebook{Dataset: dataset, Variables: variables}, nil
+}
+
+// tableVariables returns the names of the variables to export univariate tables of.
+func tableVariables(codebook *Codebook) []string {
+	switch *tables {
+	case "none":
+		return nil
+	case "all":
+		names := make([]string, 0, len(codebook.Variables))
+		for _, v := range codebook.Variables {
+			names = append(names, v.Name)
+		}
+		return names
+	}
+	return strings.Split(*tables, ",")
+}
+
+// writeJSON writes v to path as indented JSON.
+func writeJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
+
+// writeCodebookCSV writes the codebook to path as CSV with a row for each category.
+func writeCodebookCSV(path string, codebook *Codebook) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cw := csv.NewWriter(f)
+	_ = cw.Write([]string{"variable", "variable_label", "code", "label"})
+	for _, v := range codebook.Variables {
+		for _, c := range v.Categories {
+			_ = cw.Write([]string{v.Name, v.Label, c.Code, c.Label})
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
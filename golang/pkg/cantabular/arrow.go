@@ -0,0 +1,111 @@
+package cantabular
+
+import (
+	"context"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+)
+
+// QueryArrow makes the same query as Query but returns the table as Arrow record
+// batches, see Table.ArrowRecords.
+func (c *Client) QueryArrow(ctx context.Context, dataset string, vars []string, filters []Filter,
+	mem memory.Allocator, batchRows int) ([]arrow.Record, error) {
+	t, err := c.Query(ctx, dataset, vars, filters)
+	if err != nil {
+		return nil, err
+	}
+	return t.ArrowRecords(mem, batchRows), nil
+}
+
+// ArrowSchema returns the schema of the Arrow records of the table: a column of the
+// category labels of each dimension, named by its variable and dictionary encoded,
+// followed by a count column, which is int64 unless the table is Weighted, when it is float64.
+func (t *Table) ArrowSchema() *arrow.Schema {
+	fields := make([]arrow.Field, 0, len(t.Dimensions)+1)
+	for _, d := range t.Dimensions {
+		fields = append(fields, arrow.Field{
+			Name:     d.Variable.Name,
+			Type:     &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String},
+			Metadata: arrow.NewMetadata([]string{"label"}, []string{d.Variable.Label}),
+		})
+	}
+	count := arrow.Field{Name: "count", Type: arrow.PrimitiveTypes.Int64}
+	if t.Weighted() {
+		count.Type = arrow.PrimitiveTypes.Float64
+	}
+	fields = append(fields, count)
+	return arrow.NewSchema(fields, nil)
+}
+
+// ArrowRecords returns the rows of the table, in the order of ForEachRow, as Arrow
+// record batches of at most batchRows rows allocated from mem. The categories of each
+// dimension are the dictionary of its column, shared by every batch, so each row holds
+// just an index per dimension rather than its categories, and the records may be
+// written to Arrow based formats without further conversion. The caller must release
+// the records.
+func (t *Table) ArrowRecords(mem memory.Allocator, batchRows int) []arrow.Record {
+	schema := t.ArrowSchema()
+	dicts := make([]arrow.Array, len(t.Dimensions))
+	strides := make([]int, len(t.Dimensions))
+	stride := 1
+	for i := len(t.Dimensions) - 1; i >= 0; i-- {
+		b := array.NewStringBuilder(mem)
+		for _, c := range t.Dimensions[i].Categories {
+			b.Append(c.Label)
+		}
+		dicts[i] = b.NewArray()
+		b.Release()
+		strides[i] = stride
+		stride *= t.Dimensions[i].Count
+	}
+	defer func() {
+		for _, d := range dicts {
+			d.Release()
+		}
+	}()
+
+	if batchRows <= 0 {
+		batchRows = len(t.Values)
+	}
+	var records []arrow.Record
+	for start := 0; start < len(t.Values); start += batchRows {
+		end := min(start+batchRows, len(t.Values))
+		columns := make([]arrow.Array, 0, len(t.Dimensions)+1)
+		for i, d := range t.Dimensions {
+			b := array.NewInt32Builder(mem)
+			b.Reserve(end - start)
+			for row := start; row < end; row++ {
+				b.UnsafeAppend(int32(row / strides[i] % d.Count))
+			}
+			indices := b.NewArray()
+			columns = append(columns, array.NewDictionaryArray(schema.Field(i).Type, indices, dicts[i]))
+			indices.Release()
+			b.Release()
+		}
+		columns = append(columns, countArray(mem, schema.Field(len(t.Dimensions)).Type, t.Values[start:end]))
+		records = append(records, array.NewRecord(schema, columns, int64(end-start)))
+		for _, c := range columns {
+			c.Release()
+		}
+	}
+	return records
+}
+
+// countArray returns the values as an array of the type of the count column.
+func countArray(mem memory.Allocator, typ arrow.DataType, values []float64) arrow.Array {
+	if typ.ID() == arrow.FLOAT64 {
+		b := array.NewFloat64Builder(mem)
+		defer b.Release()
+		b.AppendValues(values, nil)
+		return b.NewArray()
+	}
+	b := array.NewInt64Builder(mem)
+	defer b.Release()
+	b.Reserve(len(values))
+	for _, v := range values {
+		b.UnsafeAppend(int64(v))
+	}
+	return b.NewArray()
+}
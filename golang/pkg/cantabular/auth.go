@@ -0,0 +1,27 @@
+package cantabular
+
+import "net/http"
+
+// TokenEnv is the environment variable from which the example commands take the
+// token to authenticate with when none is given by flag.
+const TokenEnv = "CANTABULAR_API_TOKEN"
+
+// WithAuthToken makes the client authenticate its requests with token, as set by
+// SetAuthHeaders. An empty token sends no credentials.
+func WithAuthToken(token, apiKeyHeader string) Option {
+	return func(client *Client) { client.authToken, client.apiKeyHeader = token, apiKeyHeader }
+}
+
+// SetAuthHeaders sets the headers authenticating a request with token, for an API
+// behind an authenticating proxy: an Authorization: Bearer header and, if
+// apiKeyHeader is not empty, that header with the token as its value. Nothing is
+// set if token is empty.
+func SetAuthHeaders(h http.Header, token, apiKeyHeader string) {
+	if token == "" {
+		return
+	}
+	h.Set("Authorization", "Bearer "+token)
+	if apiKeyHeader != "" {
+		h.Set(apiKeyHeader, token)
+	}
+}
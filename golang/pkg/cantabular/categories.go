@@ -0,0 +1,130 @@
+package cantabular
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// VariableNamesQuery is the GraphQL query used to obtain the names of the variables of a dataset.
+const VariableNamesQuery = `
+query($dataset: String!) {
+ dataset(name: $dataset) {
+  variables { edges { node { name } } }
+ }
+}`
+
+// CategoriesQuery is the GraphQL query used to obtain variables with their categories.
+const CategoriesQuery = `
+query($dataset: String!, $variables: [String!]!) {
+ dataset(name: $dataset) {
+  variables(names: $variables) {
+   edges { node { name label description categories { edges { node { code label } } } } }
+  }
+ }
+}`
+
+// VariableCategories is a variable of a dataset with its description and categories.
+type VariableCategories struct {
+	Name        string     `json:"name"`
+	Label       string     `json:"label"`
+	Description string     `json:"description,omitempty"`
+	Categories  []Category `json:"categories"`
+}
+
+// VariableNames returns the names of the variables of dataset.
+func (c *Client) VariableNames(ctx context.Context, dataset string) ([]string, error) {
+	var data struct {
+		Dataset struct {
+			Variables struct {
+				Edges []struct{ Node struct{ Name string } }
+			}
+		}
+	}
+	if err := c.Do(ctx, VariableNamesQuery, map[string]interface{}{"dataset": dataset}, &data); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(data.Dataset.Variables.Edges))
+	for _, e := range data.Dataset.Variables.Edges {
+		names = append(names, e.Node.Name)
+	}
+	return names, nil
+}
+
+// Categories obtains each of vars of dataset with its categories, in the order of vars.
+// Each variable is requested separately, with up to parallel queries at once, as a
+// single query for many variables of a large dataset can take minutes. If a query
+// fails then the rest are cancelled, and the error names the variable.
+func (c *Client) Categories(ctx context.Context, dataset string, vars []string, parallel int) ([]VariableCategories, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := make([]VariableCategories, len(vars))
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	indices := make(chan int)
+	for n := 0; n < max(parallel, 1); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				err := c.variableCategories(ctx, dataset, vars[i], &result[i])
+				if err == nil {
+					continue
+				}
+				mu.Lock()
+				if firstErr == nil {
+					// later errors are most likely due to the cancellation
+					firstErr = fmt.Errorf("%s: %w", vars[i], err)
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range vars {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return result, nil
+}
+
+// variableCategories obtains the variable of dataset with its categories into v.
+func (c *Client) variableCategories(ctx context.Context, dataset, variable string, v *VariableCategories) error {
+	var data struct {
+		Dataset struct {
+			Variables struct {
+				Edges []struct {
+					Node struct {
+						Name, Label, Description string
+						Categories               struct {
+							Edges []struct{ Node Category }
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := c.Do(ctx, CategoriesQuery, map[string]interface{}{
+		"dataset":   dataset,
+		"variables": []string{variable},
+	}, &data); err != nil {
+		return err
+	}
+	if len(data.Dataset.Variables.Edges) != 1 {
+		return errors.New("no such variable")
+	}
+	n := data.Dataset.Variables.Edges[0].Node
+	*v = VariableCategories{Name: n.Name, Label: n.Label, Description: n.Description}
+	for _, e := range n.Categories.Edges {
+		v.Categories = append(v.Categories, e.Node)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+// Package cantabular is a client for the Cantabular extended API, factored out of
+// the example commands so that applications can embed it.
+package cantabular
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// TableQuery is the GraphQL query used to obtain a table.
+const TableQuery = `
+query($dataset: String!, $variables: [String!]!, $filters: [Filter!]) {
+ dataset(name: $dataset) {
+  table(variables: $variables, filters: $filters) {
+   dimensions {
+    count
+    variable { name label }
+    categories { code label } }
+   values
+   error
+  }
+ }
+}`
+
+// Client makes queries to the extended API. A Client is safe for concurrent use by
+// multiple goroutines, as its configuration is not changed once NewClient returns
+// and each call has its own context, so a server should create one and share it
+// between the goroutines handling requests. Its connections are then pooled by the
+// one transport, see WithHTTPClient.
+type Client struct {
+	url          string
+	httpClient   *http.Client
+	hooks        []Hooks
+	authToken    string
+	apiKeyHeader string
+	headers      http.Header
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send requests with c rather than http.DefaultClient.
+// Its transport should be shared by every client of the same API, rather than one
+// made for each, so that connections are reused and their number is bounded by its
+// MaxIdleConnsPerHost and MaxConnsPerHost.
+func WithHTTPClient(c *http.Client) Option {
+	return func(client *Client) { client.httpClient = c }
+}
+
+// WithHooks adds hooks which are called for every request the client makes.
+// Hooks are called in the order they were added.
+func WithHooks(h Hooks) Option {
+	return func(client *Client) { client.hooks = append(client.hooks, h) }
+}
+
+// NewClient returns a client for the extended API at url, e.g. http://localhost:8492/graphql.
+func NewClient(url string, opts ...Option) *Client {
+	c := &Client{url: url, httpClient: http.DefaultClient}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Query obtains the table of vars in dataset, restricted by any filters.
+// The error is a *GraphQLError if the query failed, or a *TableError if the
+// table was blocked.
+func (c *Client) Query(ctx context.Context, dataset string, vars []string, filters []Filter) (*Table, error) {
+	var data struct {
+		Dataset struct{ Table Table }
+	}
+	if err := c.Do(ctx, TableQuery, tableVariables(dataset, vars, filters), &data); err != nil {
+		return nil, err
+	}
+	t := &data.Dataset.Table
+	if t.Error != "" {
+		return nil, &TableError{t.Error}
+	}
+	return t, nil
+}
+
+// QueryStream makes the same query as Query but returns the undecoded response
+// body, so that the table can be processed as it is received without holding
+// it in memory. The caller must close the body.
+func (c *Client) QueryStream(ctx context.Context, dataset string, vars []string, filters []Filter) (io.ReadCloser, error) {
+	resp, err := c.Post(ctx, TableQuery, tableVariables(dataset, vars, filters))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Do posts a GraphQL query and its variables and decodes the data part of the
+// response into data, which should be a pointer. The error is a *GraphQLError
+// if the response contains any GraphQL errors.
+func (c *Client) Do(ctx context.Context, query string, variables map[string]interface{}, data interface{}) error {
+	resp, err := c.Post(ctx, query, variables)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	gqlResp := Response{Data: data}
+	if err := json.NewDecoder(resp.Body).Decode(&gqlResp); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if len(gqlResp.Errors) > 0 {
+		return &GraphQLError{gqlResp.Errors}
+	}
+	return nil
+}
+
+// Post posts a GraphQL query and its variables and returns the response, which
+// the caller must close. The error is a *StatusError if the status is not 200 OK.
+func (c *Client) Post(ctx context.Context, query string, variables map[string]interface{}) (*http.Response, error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	}); err != nil {
+		return nil, fmt.Errorf("encoding JSON request body: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, &b)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SetAuthHeaders(req.Header, c.authToken, c.apiKeyHeader)
+	SetHeaders(req.Header, c.headers)
+	for _, h := range c.hooks {
+		h.OnRequest(req)
+	}
+	resp, err := c.httpClient.Do(req)
+	for _, h := range c.hooks {
+		h.OnResponse(req, resp, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, &StatusError{resp.StatusCode, resp.Status}
+	}
+	return resp, nil
+}
+
+func tableVariables(dataset string, vars []string, filters []Filter) map[string]interface{} {
+	return map[string]interface{}{
+		"dataset":   dataset,
+		"variables": vars,
+		"filters":   filters,
+	}
+}
+
+// Hooks are called for each request a client makes, so that embedders can add their
+// own logging, metrics or request mutation. See WithHooks. They are called from the
+// goroutine making the request, so must be safe for concurrent use if the client is
+// shared.
+type Hooks interface {
+	// OnRequest is called before the request is sent, and may modify it.
+	OnRequest(req *http.Request)
+	// OnResponse is called once the response headers are received, or the request failed,
+	// in which case resp is nil and err is the error.
+	OnResponse(req *http.Request, resp *http.Response, err error)
+}
+
+// GraphQLError is returned when the response contains GraphQL errors.
+type GraphQLError struct {
+	Errors []struct{ Message string }
+}
+
+func (e *GraphQLError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Message)
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// TableError is returned when the table is blocked, for example by disclosure control rules.
+type TableError struct {
+	Message string
+}
+
+func (e *TableError) Error() string { return "Table blocked: " + e.Message }
+
+// StatusError is returned when the API responds with a status other than 200 OK.
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string { return e.Status }
@@ -0,0 +1,65 @@
+package cantabular
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// Headers is a flag.Value for extra headers to send with each request, given as
+// "Name: value", for deployments behind gateways which expect tenant, tracing or
+// cookie headers. The flag may be repeated, and a name repeated to send several values.
+type Headers http.Header
+
+func (h *Headers) String() string {
+	if h == nil {
+		return ""
+	}
+	names := make([]string, 0, len(*h))
+	for name := range *h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var s []string
+	for _, name := range names {
+		for _, v := range (*h)[name] {
+			s = append(s, name+": "+v)
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+func (h *Headers) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("expected <name>: <value> but got %q", s)
+	}
+	if *h == nil {
+		*h = Headers{}
+	}
+	http.Header(*h).Add(name, strings.TrimSpace(value))
+	return nil
+}
+
+// WithHeaders makes the client send h with each request, replacing any headers of
+// the same names which the client would otherwise set.
+func WithHeaders(h http.Header) Option {
+	return func(client *Client) {
+		if client.headers == nil {
+			client.headers = http.Header{}
+		}
+		for name, values := range h {
+			name = textproto.CanonicalMIMEHeaderKey(name)
+			client.headers[name] = append(client.headers[name], values...)
+		}
+	}
+}
+
+// SetHeaders sets the headers of h in dst, replacing any values of the same names.
+func SetHeaders(dst, h http.Header) {
+	for name, values := range h {
+		dst[textproto.CanonicalMIMEHeaderKey(name)] = append([]string(nil), values...)
+	}
+}
@@ -0,0 +1,367 @@
+package cantabular
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// TableReader reads a table in two phases. OpenTable returns it once the dimensions
+// of the table have been received, so that callers may create schemas or database
+// tables, or size progress bars, before reading any value. Next then decodes the
+// values one at a time as they are received:
+//
+//	tr, err := client.OpenTable(ctx, dataset, vars, nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer tr.Close()
+//	// create the output from tr.Dimensions and tr.Len()
+//	for tr.Next() {
+//		row := tr.Row()
+//		...
+//	}
+//	return tr.Err()
+//
+// Unlike a Client, a TableReader must only be used by one goroutine at a time.
+type TableReader struct {
+	// Dimensions are the dimensions of the table, in the order of its variables.
+	Dimensions []Dimension
+
+	body    io.ReadCloser
+	dec     *json.Decoder
+	hasDims bool
+	indices []int
+	row     Row
+	read    int // values read, so the number of cells of the table is not exceeded
+	done    bool
+	err     error
+}
+
+// OpenTable makes the same query as Query and decodes the response up to the
+// values of the table. As with Query the error is a *GraphQLError if the query
+// failed or a *TableError if the table was blocked, when either is reported before
+// the values. The caller must close the reader.
+func (c *Client) OpenTable(ctx context.Context, dataset string, vars []string, filters []Filter) (*TableReader, error) {
+	body, err := c.QueryStream(ctx, dataset, vars, filters)
+	if err != nil {
+		return nil, err
+	}
+	tr := &TableReader{body: body, dec: json.NewDecoder(body)}
+	tr.dec.UseNumber()
+	if err := tr.open(); err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+	return tr, nil
+}
+
+// Len returns the number of cells of the table, which is the number of rows Next reads.
+func (tr *TableReader) Len() int {
+	n := 1
+	for _, d := range tr.Dimensions {
+		n *= d.Count
+	}
+	return n
+}
+
+// Next decodes the next value of the table, in row-major order, returning false at
+// the end of the table or on error, see Err.
+func (tr *TableReader) Next() bool {
+	if tr.done {
+		return false
+	}
+	if !tr.dec.More() {
+		tr.done = true
+		tr.err = tr.finish()
+		return false
+	}
+	if tr.read >= tr.Len() {
+		tr.done, tr.err = true, errors.New("decoding response: more values than cells of the dimensions")
+		return false
+	}
+	if tr.read > 0 {
+		for j := len(tr.indices) - 1; j >= 0; j-- {
+			if tr.indices[j]++; tr.indices[j] < tr.Dimensions[j].Count {
+				break
+			}
+			tr.indices[j] = 0
+		}
+	}
+	tr.read++
+	var n json.Number
+	err := tr.dec.Decode(&n)
+	if err == nil {
+		tr.row.Count, err = n.Float64()
+	}
+	if err != nil {
+		tr.done, tr.err = true, fmt.Errorf("decoding response: %w", err)
+		return false
+	}
+	for j, k := range tr.indices {
+		if k >= len(tr.Dimensions[j].Categories) {
+			tr.done, tr.err = true, fmt.Errorf("decoding response: dimension %s has fewer categories than its count", tr.Dimensions[j].Variable.Name)
+			return false
+		}
+		tr.row.Categories[j] = tr.Dimensions[j].Categories[k]
+	}
+	return true
+}
+
+// Row returns the row of the value decoded by Next. It is reused between calls.
+func (tr *TableReader) Row() *Row {
+	return &tr.row
+}
+
+// Err returns the error which stopped Next, if any. As with Query it is a
+// *GraphQLError or *TableError if either is reported after the values.
+func (tr *TableReader) Err() error {
+	return tr.err
+}
+
+// Close closes the response, which may be done before all of the values are read.
+func (tr *TableReader) Close() error {
+	return tr.body.Close()
+}
+
+// open decodes the response up to the first of the values of the table.
+func (tr *TableReader) open() error {
+	errNoTable := errors.New("decoding response: no table in response")
+	if ok, err := tr.object(); err != nil || !ok {
+		if err == nil {
+			err = errNoTable
+		}
+		return err
+	}
+	for tr.dec.More() {
+		name, err := tr.name()
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "data":
+			found, err := tr.enter("dataset", "table")
+			if err == nil && found {
+				found, err = tr.table()
+			}
+			if err != nil || found {
+				return err
+			}
+		case "errors":
+			err = tr.errors()
+		default:
+			err = tr.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errNoTable
+}
+
+// table decodes the fields of the table up to its values, returning true if it
+// reaches them. Otherwise it decodes the rest of the table and the objects which
+// contain it.
+func (tr *TableReader) table() (bool, error) {
+	for tr.dec.More() {
+		name, err := tr.name()
+		if err != nil {
+			return false, err
+		}
+		switch name {
+		case "dimensions":
+			if err := tr.dec.Decode(&tr.Dimensions); err != nil {
+				return false, fmt.Errorf("decoding response: %w", err)
+			}
+			tr.hasDims = true
+		case "error":
+			err = tr.tableError()
+		case "values":
+			// the values are null if the table is blocked, in which case the error follows
+			tok, err := tr.token()
+			if err != nil {
+				return false, err
+			}
+			if tok == nil {
+				continue
+			}
+			if tok != json.Delim('[') {
+				return false, fmt.Errorf("decoding response: values: unexpected %v", tok)
+			}
+			if !tr.hasDims {
+				return false, errors.New("decoding response: values received before dimensions")
+			}
+			tr.indices = make([]int, len(tr.Dimensions))
+			tr.row.Categories = make([]Category, len(tr.Dimensions))
+			return true, nil
+		default:
+			err = tr.skip()
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if _, err := tr.token(); err != nil {
+		return false, err
+	}
+	return false, tr.leave(2)
+}
+
+// finish decodes the rest of the response after the values, which may report
+// that the table is blocked or GraphQL errors.
+func (tr *TableReader) finish() error {
+	if _, err := tr.token(); err != nil {
+		return err
+	}
+	if err := tr.fields(map[string]func() error{"error": tr.tableError}); err != nil {
+		return err
+	}
+	if err := tr.leave(2); err != nil {
+		return err
+	}
+	return tr.fields(map[string]func() error{"errors": tr.errors})
+}
+
+// enter decodes objects down the path of field names into the object at its end,
+// returning false if any on the path is null or absent, in which case the rest of
+// the objects it entered are decoded.
+func (tr *TableReader) enter(path ...string) (bool, error) {
+	for depth := 0; ; depth++ {
+		ok, err := tr.object()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, tr.leave(depth)
+		}
+		if depth == len(path) {
+			return true, nil
+		}
+		found, err := tr.seek(path[depth])
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			return false, tr.leave(depth)
+		}
+	}
+}
+
+// seek decodes the fields of an object up to the one with the name, returning
+// false having decoded the whole object if there is none.
+func (tr *TableReader) seek(name string) (bool, error) {
+	for tr.dec.More() {
+		got, err := tr.name()
+		if err != nil {
+			return false, err
+		}
+		if got == name {
+			return true, nil
+		}
+		if err := tr.skip(); err != nil {
+			return false, err
+		}
+	}
+	_, err := tr.token()
+	return false, err
+}
+
+// leave decodes the rest of the depth objects the decoder is within.
+func (tr *TableReader) leave(depth int) error {
+	for ; depth > 0; depth-- {
+		if err := tr.fields(nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fields decodes the rest of the fields of an object and its end, calling the
+// function in fns for the name of each with the decoder positioned at its value,
+// and skipping any others.
+func (tr *TableReader) fields(fns map[string]func() error) error {
+	for tr.dec.More() {
+		name, err := tr.name()
+		if err != nil {
+			return err
+		}
+		if fn := fns[name]; fn != nil {
+			err = fn()
+		} else {
+			err = tr.skip()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tr.token()
+	return err
+}
+
+// object decodes the start of an object, returning false if it is null instead.
+func (tr *TableReader) object() (bool, error) {
+	tok, err := tr.token()
+	if err != nil || tok == nil {
+		return false, err
+	}
+	if tok != json.Delim('{') {
+		return false, fmt.Errorf("decoding response: unexpected %v", tok)
+	}
+	return true, nil
+}
+
+// name decodes the name of the next field of an object.
+func (tr *TableReader) name() (string, error) {
+	tok, err := tr.token()
+	if err != nil {
+		return "", err
+	}
+	return tok.(string), nil
+}
+
+// tableError decodes the error of the table, returning it as a *TableError.
+func (tr *TableReader) tableError() error {
+	var msg *string
+	if err := tr.dec.Decode(&msg); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if msg != nil {
+		return &TableError{*msg}
+	}
+	return nil
+}
+
+// errors decodes the GraphQL errors of the response, returning any as a *GraphQLError.
+func (tr *TableReader) errors() error {
+	var gqlErr GraphQLError
+	if err := tr.dec.Decode(&gqlErr.Errors); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	if len(gqlErr.Errors) > 0 {
+		return &gqlErr
+	}
+	return nil
+}
+
+// skip decodes and discards the next value.
+func (tr *TableReader) skip() error {
+	var raw json.RawMessage
+	if err := tr.dec.Decode(&raw); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// token returns the next token, treating a truncated response as an error.
+func (tr *TableReader) token() (json.Token, error) {
+	tok, err := tr.dec.Token()
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding response: %w", err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,124 @@
+package cantabular
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy configures which requests RetryTransport retries and how often.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first; 1 or less disables retrying.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for each further retry.
+	Backoff time.Duration
+	// RetryOn are the response statuses to retry. Connection errors are always retried.
+	RetryOn []int
+	// OnRetry is called, if not nil, before waiting to make the given attempt. It is
+	// called from the goroutine making the request, so concurrently if the transport is shared.
+	OnRetry func(attempt int, delay time.Duration, reason string)
+}
+
+// RetryTransport is an http.RoundTripper which retries requests failing with a
+// connection error or a status in the RetryOn set of its policy, waiting an
+// exponentially increasing delay with jitter between attempts so that many
+// clients do not retry in step. A Retry-After header overrides the delay.
+//
+// Retrying happens only while obtaining the response: once a response which is
+// not retried is returned, its body is the caller's, so output made from a body
+// is never repeated.
+type RetryTransport struct {
+	Base   http.RoundTripper // http.DefaultTransport if nil
+	Policy RetryPolicy
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		resp, err := base.RoundTrip(r)
+		var reason string
+		switch {
+		case err != nil:
+			reason = err.Error()
+		case t.retries(resp.StatusCode):
+			reason = resp.Status
+		default:
+			return resp, nil
+		}
+		if attempt >= t.Policy.MaxAttempts || req.Context().Err() != nil ||
+			(req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		delay := t.delay(attempt, resp)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		if t.Policy.OnRetry != nil {
+			t.Policy.OnRetry(attempt+1, delay, reason)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+func (t *RetryTransport) retries(status int) bool {
+	for _, s := range t.Policy.RetryOn {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait after the given failed attempt: Backoff doubled for each
+// earlier retry, of which a random half is taken off, or as the response's Retry-After.
+func (t *RetryTransport) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+	}
+	d := t.Policy.Backoff << (attempt - 1)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// ParseStatuses parses a comma-separated list of HTTP statuses, e.g. "502,503",
+// as given to RetryPolicy.RetryOn.
+func ParseStatuses(s string) ([]int, error) {
+	var statuses []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		status, err := strconv.Atoi(f)
+		if err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("bad HTTP status %q", f)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,42 @@
+//go:build go1.23
+
+package cantabular
+
+import (
+	"context"
+	"iter"
+)
+
+// Rows makes the same query as Query but decodes the response as it is received with
+// a TableReader, yielding each cell of the table in row-major order with its
+// categories, so that tables too large to hold in memory may be ranged over:
+//
+//	for row, err := range client.Rows(ctx, dataset, vars, nil) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// An error is yielded at most once, after which iteration stops. As with Query it is
+// a *GraphQLError if the query failed or a *TableError if the table was blocked, which
+// may follow rows already yielded. The Categories of the row are reused between
+// iterations. Stopping early closes the response.
+func (c *Client) Rows(ctx context.Context, dataset string, vars []string, filters []Filter) iter.Seq2[Row, error] {
+	return func(yield func(Row, error) bool) {
+		tr, err := c.OpenTable(ctx, dataset, vars, filters)
+		if err != nil {
+			yield(Row{}, err)
+			return
+		}
+		defer func() { _ = tr.Close() }()
+		for tr.Next() {
+			if !yield(*tr.Row(), nil) {
+				return
+			}
+		}
+		if err := tr.Err(); err != nil {
+			yield(Row{}, err)
+		}
+	}
+}
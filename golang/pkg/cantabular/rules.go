@@ -0,0 +1,77 @@
+package cantabular
+
+import "context"
+
+// RulesQuery is the GraphQL query used to obtain the disclosure control status of a
+// table: the rule variable of the dataset, and how its categories fared under the rules,
+// without the values of the table.
+const RulesQuery = `
+query($dataset: String!, $variables: [String!]!, $filters: [Filter!]) {
+ dataset(name: $dataset) {
+  ruleBase { name }
+  table(variables: $variables, filters: $filters) {
+   rules {
+    evaluated { count }
+    passed { count }
+    blocked { count }
+    total { count } }
+   error
+  }
+ }
+}`
+
+type (
+	// Rules reports how many categories of the rule variable of the dataset were
+	// evaluated by its disclosure control rules for a table, and how many of those
+	// passed or were blocked, out of the total.
+	Rules struct {
+		Evaluated RuleCount `json:"evaluated"`
+		Passed    RuleCount `json:"passed"`
+		Blocked   RuleCount `json:"blocked"`
+		Total     RuleCount `json:"total"`
+	}
+
+	// RuleCount is a count of categories of the rule variable.
+	RuleCount struct {
+		Count int `json:"count"`
+	}
+
+	// RuleReport describes the disclosure control status of a table query.
+	RuleReport struct {
+		Dataset      string   `json:"dataset"`
+		Variables    []string `json:"variables"`
+		Filters      []Filter `json:"filters,omitempty"`
+		RuleVariable string   `json:"rule_variable,omitempty"` // empty if the dataset has no rule variable
+		Rules        *Rules   `json:"rules,omitempty"`
+		Blocked      bool     `json:"blocked"`
+		Error        string   `json:"error,omitempty"` // why the table is blocked
+	}
+)
+
+// QueryRules obtains the disclosure control status of the table of vars in dataset,
+// restricted by any filters. A blocked table is reported rather than returned as a
+// *TableError, so that the report can say why. The error is a *GraphQLError if the
+// query failed.
+func (c *Client) QueryRules(ctx context.Context, dataset string, vars []string, filters []Filter) (*RuleReport, error) {
+	var data struct {
+		Dataset struct {
+			RuleBase *struct{ Name string }
+			Table    Table
+		}
+	}
+	if err := c.Do(ctx, RulesQuery, tableVariables(dataset, vars, filters), &data); err != nil {
+		return nil, err
+	}
+	r := &RuleReport{
+		Dataset:   dataset,
+		Variables: vars,
+		Filters:   filters,
+		Rules:     data.Dataset.Table.Rules,
+		Blocked:   data.Dataset.Table.Error != "",
+		Error:     data.Dataset.Table.Error,
+	}
+	if data.Dataset.RuleBase != nil {
+		r.RuleVariable = data.Dataset.RuleBase.Name
+	}
+	return r, nil
+}
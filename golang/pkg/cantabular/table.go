@@ -0,0 +1,139 @@
+package cantabular
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+)
+
+type (
+	// Response is a GraphQL response
+	Response struct {
+		Data   interface{}
+		Errors []struct{ Message string }
+	}
+
+	// Table is a table returned by the API
+	Table struct {
+		Dimensions []Dimension `json:"dimensions"`
+		Values     []float64   `json:"values"` // fractional for weighted datasets
+		Error      string      `json:"error,omitempty"`
+		Rules      *Rules      `json:"rules,omitempty"` // only requested by RulesQuery
+	}
+
+	// Dimension describes one of the variables of a table and its categories
+	Dimension struct {
+		Count      int        `json:"count"`
+		Categories []Category `json:"categories"`
+		Variable   Variable   `json:"variable"`
+	}
+
+	// Variable identifies a variable
+	Variable struct {
+		Name  string `json:"name"`
+		Label string `json:"label"`
+	}
+
+	// Category represents one of the possible values of a variable
+	Category struct {
+		Code  string `json:"code"`
+		Label string `json:"label"`
+	}
+
+	// Row is a cell of a table with its categories
+	Row struct {
+		Categories []Category
+		Count      float64
+	}
+
+	// Filter restricts a variable to some of its categories in a table query
+	Filter struct {
+		Variable string   `json:"variable"`
+		Codes    []string `json:"codes"`
+	}
+)
+
+// RowOption selects the rows of a table for which ForEachRow calls its function,
+// returning false to skip the row.
+type RowOption func(row *Row) bool
+
+// SkipZeros is a RowOption skipping the rows with a count of zero, which are most
+// of the rows of sparse tables such as census crosstabs.
+func SkipZeros(row *Row) bool { return row.Count != 0 }
+
+// ForEachRow calls the provided function for each row of the table, in row-major order,
+// which all of opts select. The row is reused between calls.
+func (t *Table) ForEachRow(cb func(row *Row), opts ...RowOption) {
+	indices := make([]int, len(t.Dimensions))
+	row := Row{Categories: make([]Category, len(t.Dimensions))}
+	for _, value := range t.Values {
+		for j, k := range indices {
+			row.Categories[j] = t.Dimensions[j].Categories[k]
+		}
+		row.Count = value
+		if selected(&row, opts) {
+			cb(&row)
+		}
+
+		for j := len(indices) - 1; j >= 0; j-- {
+			if indices[j]++; indices[j] < t.Dimensions[j].Count {
+				break
+			}
+			indices[j] = 0
+		}
+	}
+}
+
+func selected(row *Row, opts []RowOption) bool {
+	for _, opt := range opts {
+		if !opt(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// Header returns the variable labels followed by "count", as for a CSV header.
+func (t *Table) Header() []string {
+	result := make([]string, 0, len(t.Dimensions)+1)
+	for _, d := range t.Dimensions {
+		result = append(result, d.Variable.Label)
+	}
+	return append(result, "count")
+}
+
+// Weighted returns whether any value of the table is fractional, as the values of
+// weighted datasets may be.
+func (t *Table) Weighted() bool {
+	for _, v := range t.Values {
+		if v != math.Trunc(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatValue formats a value of a table with the number of decimal places, or
+// if decimals is negative with as few digits as represent it exactly, so that
+// whole numbers have no decimal point.
+func FormatValue(v float64, decimals int) string {
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// WriteCSV writes the table to w as CSV with the Header, then the category labels and
+// count of each row.
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write(t.Header())
+	columns := make([]string, 0, len(t.Dimensions)+1)
+	t.ForEachRow(func(row *Row) {
+		columns = columns[:0]
+		for _, c := range row.Categories {
+			columns = append(columns, c.Label)
+		}
+		_ = cw.Write(append(columns, FormatValue(row.Count, -1)))
+	})
+	cw.Flush()
+	return cw.Error()
+}
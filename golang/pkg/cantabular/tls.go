@@ -0,0 +1,48 @@
+package cantabular
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSOptions configure TLS connections to the API, for servers deployed with a
+// private CA or which require clients to authenticate with certificates.
+type TLSOptions struct {
+	CAFile   string // PEM certificates of the CAs to trust instead of the system's
+	CertFile string // PEM client certificate, for mutual TLS
+	KeyFile  string // PEM private key of the client certificate
+	Insecure bool   // skip verification of the server's certificate
+}
+
+// Config returns the TLS configuration of the options, or nil if none are set so
+// that the defaults apply.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	if o == (TLSOptions{}) {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no PEM certificates found", o.CAFile)
+		}
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("a client certificate and its key must be given together")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,65 @@
+package cantabular
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// TransportOptions configure the connections made to the API.
+type TransportOptions struct {
+	TLS TLSOptions
+	// Proxy is the URL of an http, https, socks5 or socks5h proxy to connect
+	// through, or empty to use any proxy given by $HTTPS_PROXY, $HTTP_PROXY and
+	// $NO_PROXY, as http.ProxyFromEnvironment does.
+	Proxy string
+	// IdleConns is the number of connections to the API kept open for reuse between
+	// requests, which should be at least the number of requests made at once by the
+	// clients sharing the transport, or 0 for the 2 of http.DefaultTransport.
+	IdleConns int
+}
+
+// ProxyFunc returns the function choosing the proxy of a request, as for the
+// Proxy field of http.Transport and of websocket dialers.
+func (o TransportOptions) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if o.Proxy == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(o.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %s: expected an http, https, socks5 or socks5h URL", u.Redacted())
+	}
+	return http.ProxyURL(u), nil
+}
+
+// Transport returns an http.RoundTripper making connections with the options, or
+// http.DefaultTransport if none are set. Otherwise each call returns a transport
+// with its own pool of connections, so it should be called once and the result
+// shared, as by the clients of a server.
+func (o TransportOptions) Transport() (http.RoundTripper, error) {
+	if o == (TransportOptions{}) {
+		return http.DefaultTransport, nil
+	}
+	config, err := o.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := o.ProxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig, t.Proxy = config, proxy
+	if o.IdleConns > 0 {
+		t.MaxIdleConnsPerHost = o.IdleConns
+		if t.MaxIdleConns < o.IdleConns {
+			t.MaxIdleConns = o.IdleConns
+		}
+	}
+	return t, nil
+}
@@ -0,0 +1,288 @@
+package httpconvert
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow/go/v17/arrow"
+	"github.com/apache/arrow/go/v17/arrow/array"
+	"github.com/apache/arrow/go/v17/arrow/ipc"
+	"github.com/apache/arrow/go/v17/arrow/memory"
+
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// Format is a format tables are converted to, identified by its media type.
+type Format string
+
+const (
+	// CSV has a header of the labels of the variables and count, and a row of the
+	// labels of the categories and the count of each cell.
+	CSV Format = "text/csv"
+	// JSON is an array of the rows of CSV as objects keyed by its header.
+	JSON Format = "application/json"
+	// NDJSON is the objects of JSON, one on each line, so that a client may process
+	// each row as it arrives.
+	NDJSON Format = "application/x-ndjson"
+	// Arrow is an Arrow IPC stream of record batches with the columns of
+	// cantabular.Table.ArrowSchema, except that the count is always float64, as
+	// whether the table is weighted isn't known until all of it has been received.
+	Arrow Format = "application/vnd.apache.arrow.stream"
+)
+
+// Formats are the formats in order of preference, where a client accepts several equally.
+var Formats = []Format{CSV, JSON, NDJSON, Arrow}
+
+// arrowBatchRows is the number of rows of each Arrow record batch.
+const arrowBatchRows = 65536
+
+// ContentType returns the Content-Type header of a response in the format.
+func (f Format) ContentType() string {
+	if f == Arrow {
+		return string(f)
+	}
+	return string(f) + "; charset=utf-8"
+}
+
+// Negotiate returns the format preferred by the Accept header of a request, CSV if
+// there is none, or false if none of the Formats are acceptable. Media ranges such
+// as text/* and */* are matched, and the quality of the most specific range matching
+// each format is used, as in RFC 9110.
+func Negotiate(accept string) (Format, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return CSV, true
+	}
+	best, bestQ := Format(""), 0.0
+	for _, f := range Formats {
+		q, specificity := 0.0, -1
+		for _, r := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(r))
+			if err != nil {
+				continue
+			}
+			s := matchMediaRange(mediaType, string(f))
+			if s <= specificity {
+				continue
+			}
+			q, specificity = 1, s
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					q = 0
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// matchMediaRange returns how specifically the media range matches mediaType: 2 for
+// the type itself, 1 for type/*, 0 for */* and -1 if it doesn't match.
+func matchMediaRange(mediaRange, mediaType string) int {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	switch mediaRange {
+	case mediaType:
+		return 2
+	case typ + "/*":
+		return 1
+	case "*/*":
+		return 0
+	}
+	return -1
+}
+
+// rowWriter writes the rows of a table in a format.
+type rowWriter interface {
+	// writeRow writes the row of the current cell of ti, with its count.
+	writeRow(ti *table.Iterator, count json.Number) error
+	// close completes the table.
+	close() error
+}
+
+// newRowWriter returns a writer of the rows of a table of dims in the format to w.
+func newRowWriter(f Format, w io.Writer, dims table.Dimensions) rowWriter {
+	switch f {
+	case JSON, NDJSON:
+		return newJSONWriter(w, dims, f == JSON)
+	case Arrow:
+		return newArrowWriter(w, dims)
+	default:
+		return newCSVWriter(w, dims)
+	}
+}
+
+type csvWriter struct {
+	cw      *csv.Writer
+	columns []string
+	dims    int
+}
+
+func newCSVWriter(w io.Writer, dims table.Dimensions) *csvWriter {
+	cw := csv.NewWriter(w)
+	columns := make([]string, 0, len(dims)+1)
+	for _, d := range dims {
+		columns = append(columns, d.Variable.Label)
+	}
+	_ = cw.Write(append(columns, "count"))
+	return &csvWriter{cw: cw, columns: columns, dims: len(dims)}
+}
+
+func (c *csvWriter) writeRow(ti *table.Iterator, count json.Number) error {
+	c.columns = c.columns[:0]
+	for i := 0; i < c.dims; i++ {
+		c.columns = append(c.columns, ti.CategoryAtColumn(i).Label)
+	}
+	return c.cw.Write(append(c.columns, count.String()))
+}
+
+func (c *csvWriter) close() error {
+	c.cw.Flush()
+	return c.cw.Error()
+}
+
+// jsonWriter writes rows as objects keyed by the header of the CSV, either as the
+// elements of an array or one on each line.
+type jsonWriter struct {
+	w     io.Writer
+	array bool
+	keys  [][]byte // quoted keys of the objects, with their colons
+	buf   []byte
+	rows  int
+}
+
+func newJSONWriter(w io.Writer, dims table.Dimensions, array bool) *jsonWriter {
+	j := &jsonWriter{w: w, array: array}
+	for _, d := range dims {
+		j.keys = append(j.keys, append(appendJSONString(nil, d.Variable.Label), ':'))
+	}
+	j.keys = append(j.keys, []byte(`"count":`))
+	return j
+}
+
+func (j *jsonWriter) writeRow(ti *table.Iterator, count json.Number) error {
+	j.buf = j.buf[:0]
+	switch {
+	case !j.array:
+	case j.rows == 0:
+		j.buf = append(j.buf, '[')
+	default:
+		j.buf = append(j.buf, ',')
+	}
+	j.buf = append(j.buf, '{')
+	for i := 0; i < len(j.keys)-1; i++ {
+		j.buf = append(j.buf, j.keys[i]...)
+		j.buf = appendJSONString(j.buf, ti.CategoryAtColumn(i).Label)
+		j.buf = append(j.buf, ',')
+	}
+	j.buf = append(j.buf, j.keys[len(j.keys)-1]...)
+	j.buf = append(append(j.buf, count...), '}', '\n')
+	j.rows++
+	_, err := j.w.Write(j.buf)
+	return err
+}
+
+func (j *jsonWriter) close() error {
+	switch {
+	case !j.array:
+		return nil
+	case j.rows == 0:
+		_, err := io.WriteString(j.w, "[]\n")
+		return err
+	default:
+		_, err := io.WriteString(j.w, "]\n")
+		return err
+	}
+}
+
+// appendJSONString appends s to buf as a JSON string.
+func appendJSONString(buf []byte, s string) []byte {
+	b, _ := json.Marshal(s) // a string cannot fail to marshal
+	return append(buf, b...)
+}
+
+// arrowWriter writes rows as Arrow record batches of arrowBatchRows rows, with the
+// categories of each dimension as the dictionary of its column.
+type arrowWriter struct {
+	w       *ipc.Writer
+	schema  *arrow.Schema
+	dicts   []arrow.Array
+	indices []*array.Int32Builder
+	counts  *array.Float64Builder
+}
+
+func newArrowWriter(w io.Writer, dims table.Dimensions) *arrowWriter {
+	mem := memory.DefaultAllocator
+	fields := make([]arrow.Field, 0, len(dims)+1)
+	a := &arrowWriter{counts: array.NewFloat64Builder(mem)}
+	for _, d := range dims {
+		fields = append(fields, arrow.Field{
+			Name:     d.Variable.Name,
+			Type:     &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int32, ValueType: arrow.BinaryTypes.String},
+			Metadata: arrow.NewMetadata([]string{"label"}, []string{d.Variable.Label}),
+		})
+		b := array.NewStringBuilder(mem)
+		for _, c := range d.Categories {
+			b.Append(c.Label)
+		}
+		a.dicts = append(a.dicts, b.NewArray())
+		b.Release()
+		a.indices = append(a.indices, array.NewInt32Builder(mem))
+	}
+	a.schema = arrow.NewSchema(append(fields, arrow.Field{Name: "count", Type: arrow.PrimitiveTypes.Float64}), nil)
+	a.w = ipc.NewWriter(w, ipc.WithSchema(a.schema), ipc.WithAllocator(mem))
+	return a
+}
+
+func (a *arrowWriter) writeRow(ti *table.Iterator, count json.Number) error {
+	for i, b := range a.indices {
+		b.Append(int32(ti.CategoryIndexAtColumn(i)))
+	}
+	f, err := count.Float64()
+	if err != nil {
+		return err
+	}
+	if a.counts.Append(f); a.counts.Len() == arrowBatchRows {
+		return a.writeBatch()
+	}
+	return nil
+}
+
+// writeBatch writes the rows appended since the last batch as a record batch.
+func (a *arrowWriter) writeBatch() error {
+	rows := a.counts.Len()
+	columns := make([]arrow.Array, 0, len(a.indices)+1)
+	for i, b := range a.indices {
+		indices := b.NewArray()
+		columns = append(columns, array.NewDictionaryArray(a.schema.Field(i).Type, indices, a.dicts[i]))
+		indices.Release()
+	}
+	columns = append(columns, a.counts.NewArray())
+	record := array.NewRecord(a.schema, columns, int64(rows))
+	for _, c := range columns {
+		c.Release()
+	}
+	defer record.Release()
+	return a.w.Write(record)
+}
+
+func (a *arrowWriter) close() error {
+	var err error
+	if a.counts.Len() > 0 {
+		err = a.writeBatch()
+	}
+	if cerr := a.w.Close(); err == nil {
+		err = cerr
+	}
+	for i, b := range a.indices {
+		b.Release()
+		a.dicts[i].Release()
+	}
+	a.counts.Release()
+	return err
+}
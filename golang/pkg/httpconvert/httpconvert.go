@@ -0,0 +1,250 @@
+// Package httpconvert converts table responses of the Cantabular extended API to CSV,
+// or another of the Formats, as they are received, without holding the table in
+// memory, and provides an HTTP handler which serves tables in the format negotiated
+// with the client so that web applications can offer them to their own clients. It
+// is the conversion of the cantabular-query-streamed example, with errors returned
+// rather than panicking.
+package httpconvert
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cantabular/examples/pkg/cantabular"
+	"github.com/cantabular/examples/pkg/jsonstream"
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// Options adjust a conversion.
+type Options struct {
+	// OnRow is called, if not nil, after each row is written.
+	OnRow func()
+	// CheckCells is called, if not nil, with the number of cells of the table before
+	// any row is written. If it returns an error then conversion stops with that error.
+	CheckCells func(cells int) error
+	// Format is the format the table is converted to, CSV if empty.
+	Format Format
+}
+
+// Convert converts the GraphQL table response in r to CSV, or opts.Format, on w. The
+// error is a *cantabular.GraphQLError if the response has GraphQL errors, or a
+// *cantabular.TableError if the table was blocked.
+func Convert(r io.Reader, w io.Writer, opts Options) error {
+	dec := jsonstream.NewChecked(r)
+	if !dec.StartObjectComposite() {
+		if err := dec.Err(); err != nil {
+			return err
+		}
+		return errors.New("no JSON object found in response")
+	}
+	for dec.More() {
+		switch dec.DecodeName() {
+		case "data":
+			if err := convertData(dec, w, opts); err != nil {
+				return err
+			}
+		case "errors":
+			var graphqlErr cantabular.GraphQLError
+			if err := dec.Decode(&graphqlErr.Errors); err != nil {
+				return err
+			}
+			if len(graphqlErr.Errors) > 0 {
+				return &graphqlErr
+			}
+		default:
+			dec.SkipValue() // such as extensions
+		}
+	}
+	dec.EndComposite()
+	return dec.Err()
+}
+
+// convertData decodes the data of the response, converting the table of its dataset.
+// Other fields, such as those of queries which request more than the table, are skipped.
+func convertData(dec *jsonstream.CheckedDecoder, w io.Writer, opts Options) error {
+	if !dec.StartObjectComposite() {
+		return dec.Err()
+	}
+	for dec.More() {
+		if dec.DecodeName() != "dataset" {
+			dec.SkipValue()
+			continue
+		}
+		if !dec.StartObjectComposite() {
+			if err := dec.Err(); err != nil {
+				return err
+			}
+			return errors.New(`dataset object expected but "null" found`)
+		}
+		for dec.More() {
+			if dec.DecodeName() != "table" {
+				dec.SkipValue()
+			} else if dec.StartObjectComposite() {
+				if err := convertTable(dec, w, opts); err != nil {
+					return err
+				}
+				dec.EndComposite()
+			}
+		}
+		dec.EndComposite()
+	}
+	dec.EndComposite()
+	return dec.Err()
+}
+
+// convertTable decodes the fields of the table writing it to w in opts.Format.
+func convertTable(dec *jsonstream.CheckedDecoder, w io.Writer, opts Options) error {
+	var dims table.Dimensions
+	for dec.More() {
+		switch dec.DecodeName() {
+		case "dimensions":
+			if err := dec.Decode(&dims); err != nil {
+				return err
+			}
+		case "error":
+			if errMsg := dec.DecodeString(); errMsg != nil {
+				return &cantabular.TableError{Message: *errMsg}
+			}
+		case "values":
+			if !dec.StartArrayComposite() {
+				continue
+			}
+			if dims == nil {
+				return errors.New("values received before dimensions")
+			}
+			if opts.CheckCells != nil {
+				cells := 1
+				for _, d := range dims {
+					cells *= d.Count
+				}
+				if err := opts.CheckCells(cells); err != nil {
+					return err
+				}
+			}
+			rw := newRowWriter(opts.Format, w, dims)
+			for ti := dims.NewIterator(); dec.More(); ti.Next() {
+				value := dec.DecodeNumber()
+				if dec.Err() != nil {
+					break
+				}
+				if err := rw.writeRow(ti, value); err != nil {
+					_ = rw.close()
+					return err
+				}
+				if opts.OnRow != nil {
+					opts.OnRow()
+				}
+			}
+			dec.EndComposite()
+			if err := rw.close(); err != nil {
+				return err
+			}
+		default:
+			dec.SkipValue() // such as rules, of queries which request them
+		}
+	}
+	return dec.Err()
+}
+
+// Handler serves GET requests for tables in the format of the Accept header, CSV
+// unless another of the Formats is preferred, streamed as the response of Client is
+// received. Options.Format is ignored. The table is given by the parameters of the
+// request, see ParseQuery. Errors in the query are reported with status 400, an
+// Accept header allowing none of the Formats with 406, and failures to obtain the
+// table with 502. Once the table has begun its status has already been sent, so an
+// error cuts the response short instead.
+type Handler struct {
+	Client  *cantabular.Client
+	Options Options
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := h.Options
+	w.Header().Add("Vary", "Accept")
+	var ok bool
+	if opts.Format, ok = Negotiate(r.Header.Get("Accept")); !ok {
+		http.Error(w, fmt.Sprintf("none of the formats %v are acceptable", Formats), http.StatusNotAcceptable)
+		return
+	}
+
+	body, err := h.Client.QueryStream(r.Context(), q.Dataset, q.Variables, q.Filters)
+	if err != nil {
+		log.Printf("%s: %s", r.URL, err)
+		http.Error(w, fmt.Sprintf("extended API: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = body.Close() }()
+	ww := &WatchedWriter{Writer: w}
+	w.Header().Set("Content-Type", opts.Format.ContentType())
+	err = Convert(body, ww, opts)
+	switch {
+	case err == nil:
+	case ww.Written:
+		log.Printf("%s: %s", r.URL, err)
+		panic(http.ErrAbortHandler)
+	case errors.As(err, new(*cantabular.GraphQLError)) || errors.As(err, new(*cantabular.TableError)):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		log.Printf("%s: %s", r.URL, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+// Query is a table query given by the parameters of a request, see ParseQuery.
+type Query struct {
+	Dataset   string
+	Variables []string
+	Filters   []cantabular.Filter
+}
+
+// ParseQuery parses the query of a table from the parameters of a request, as Handler
+// does, so that other handlers may serve tables alike. The dataset is given by the
+// dataset parameter and the variables by variables parameters, each of which may list
+// several separated by commas. The table may be restricted by filter parameters of the
+// form <var>=<code>,<code>... The error describes invalid parameters, for a response
+// with status 400.
+func ParseQuery(params url.Values) (Query, error) {
+	q := Query{Dataset: params.Get("dataset")}
+	for _, v := range params["variables"] {
+		q.Variables = append(q.Variables, strings.Split(v, ",")...)
+	}
+	for _, s := range params["filter"] {
+		variable, codes, ok := strings.Cut(s, "=")
+		if !ok || variable == "" || codes == "" {
+			return Query{}, fmt.Errorf("expected filter <var>=<code>,<code>... but got %q", s)
+		}
+		q.Filters = append(q.Filters, cantabular.Filter{Variable: variable, Codes: strings.Split(codes, ",")})
+	}
+	if q.Dataset == "" || len(q.Variables) == 0 {
+		return Query{}, errors.New("dataset and variables parameters are required")
+	}
+	return q, nil
+}
+
+// WatchedWriter records whether anything has been written through it, so that a
+// handler knows whether it may still report an error with a status or, as the status
+// has been sent, must cut the response short.
+type WatchedWriter struct {
+	io.Writer
+	Written bool
+}
+
+func (ww *WatchedWriter) Write(p []byte) (int, error) {
+	ww.Written = true
+	return ww.Writer.Write(p)
+}
@@ -0,0 +1,128 @@
+package jsonstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// CheckedDecoder has the methods of Decoder but records the first error rather
+// than panicking, in the manner of bufio.Scanner, so that it can be used without
+// recover(). Once an error has occurred every method does nothing: the composite
+// and More methods return false and the others return zero values. Err should be
+// checked when decoding is complete.
+type CheckedDecoder struct {
+	dec Decoder
+	err error
+}
+
+// NewChecked creates a new CheckedDecoder, skipping padding as New does.
+func NewChecked(r io.Reader) *CheckedDecoder {
+	return &CheckedDecoder{dec: New(r)}
+}
+
+// Err returns the first error which occurred, or nil.
+func (c *CheckedDecoder) Err() error { return c.err }
+
+// check runs fn unless an error has already occurred, recording any panic as the error.
+func (c *CheckedDecoder) check(fn func()) {
+	if c.err != nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			switch r := r.(type) {
+			case error:
+				c.err = r
+			case string:
+				c.err = errors.New(r)
+			default:
+				c.err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	fn()
+}
+
+// More reports whether there is another element in the current array or object.
+func (c *CheckedDecoder) More() bool {
+	return c.err == nil && c.dec.More()
+}
+
+// StartObjectComposite decodes the start of a JSON object, see Decoder.StartObjectComposite.
+func (c *CheckedDecoder) StartObjectComposite() (ok bool) {
+	c.check(func() { ok = c.dec.StartObjectComposite() })
+	return ok
+}
+
+// StartArrayComposite decodes the start of a JSON array, see Decoder.StartArrayComposite.
+func (c *CheckedDecoder) StartArrayComposite() (ok bool) {
+	c.check(func() { ok = c.dec.StartArrayComposite() })
+	return ok
+}
+
+// EndComposite decodes the end of an array or object, see Decoder.EndComposite.
+func (c *CheckedDecoder) EndComposite() {
+	c.check(c.dec.EndComposite)
+}
+
+// DecodeString decodes a string or null, see Decoder.DecodeString.
+func (c *CheckedDecoder) DecodeString() (s *string) {
+	c.check(func() { s = c.dec.DecodeString() })
+	return s
+}
+
+// DecodeName decodes a JSON field name, see Decoder.DecodeName.
+func (c *CheckedDecoder) DecodeName() (name string) {
+	c.check(func() { name = c.dec.DecodeName() })
+	return name
+}
+
+// DecodeNumber decodes a number, see Decoder.DecodeNumber.
+func (c *CheckedDecoder) DecodeNumber() (n json.Number) {
+	c.check(func() { n = c.dec.DecodeNumber() })
+	return n
+}
+
+// DecodeNumberOrNull decodes a number or null, see Decoder.DecodeNumberOrNull.
+func (c *CheckedDecoder) DecodeNumberOrNull() (n *json.Number) {
+	c.check(func() { n = c.dec.DecodeNumberOrNull() })
+	return n
+}
+
+// DecodeBool decodes a boolean or null, see Decoder.DecodeBool.
+func (c *CheckedDecoder) DecodeBool() (b *bool) {
+	c.check(func() { b = c.dec.DecodeBool() })
+	return b
+}
+
+// DecodeValue decodes the value of a cell, see Decoder.DecodeValue.
+func (c *CheckedDecoder) DecodeValue() (v value.Value) {
+	c.check(func() { v = c.dec.DecodeValue() })
+	return v
+}
+
+// DecodeRawMessage decodes the next value verbatim, see Decoder.DecodeRawMessage.
+func (c *CheckedDecoder) DecodeRawMessage() (raw json.RawMessage) {
+	c.check(func() { raw = c.dec.DecodeRawMessage() })
+	return raw
+}
+
+// SkipValue decodes and discards the next value, see Decoder.SkipValue.
+func (c *CheckedDecoder) SkipValue() {
+	c.check(c.dec.SkipValue)
+}
+
+// Decode decodes the next value into v, as json.Decoder.Decode does. The error is
+// also returned so that it can be handled immediately.
+func (c *CheckedDecoder) Decode(v interface{}) error {
+	c.check(func() {
+		if err := c.dec.Decode(v); err != nil {
+			panic(err)
+		}
+	})
+	return c.err
+}
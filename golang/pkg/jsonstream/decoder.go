@@ -0,0 +1,283 @@
+package jsonstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/cantabular/examples/pkg/value"
+)
+
+// Decoder is a json.Decoder wrapper which adds convenience
+// methods for stream decoding and uses panic to simplify errors.
+// You should use recover() to catch errors from the methods.
+// Errors are *Error, giving where in the input they occurred.
+type Decoder struct {
+	*json.Decoder
+	pos *position
+}
+
+// New creates a new Decoder.
+// Decoder is a pointer type: copying does not clone state.
+// Padding before the first token is skipped, see paddingSkipper.
+func New(r io.Reader) Decoder {
+	ps := &paddingSkipper{r: r}
+	jd := json.NewDecoder(ps)
+	jd.UseNumber()
+	return Decoder{jd, &position{padding: ps}}
+}
+
+// paddingSkipper is a reader which discards padding at the start of the input.
+// Some gateways send whitespace or NUL bytes to keep a connection alive while a
+// long query runs, and a UTF-8 byte order mark may also be present. None of these
+// are valid JSON other than whitespace, so they are dropped before the first token.
+type paddingSkipper struct {
+	r       io.Reader
+	started bool
+	skipped int64
+}
+
+func (ps *paddingSkipper) Read(p []byte) (int, error) {
+	for !ps.started {
+		n, err := ps.r.Read(p)
+		i := 0
+		for i < n && isPadding(p[i]) {
+			i++
+		}
+		ps.skipped += int64(i)
+		if i < n {
+			ps.started = true
+			return copy(p, p[i:n]), err
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return ps.r.Read(p)
+}
+
+// isPadding returns true for the bytes which may precede the first token.
+// The bytes of a UTF-8 byte order mark (EF BB BF) can't start valid JSON so
+// they are included.
+func isPadding(b byte) bool {
+	switch b {
+	case ' ', '\t', '\r', '\n', 0, 0xEF, 0xBB, 0xBF:
+		return true
+	}
+	return false
+}
+
+// StartObjectComposite decodes the start of a JSON object, i.e. '{'
+// It returns false if a null was found, in which case there is no end to decode.
+func (dec Decoder) StartObjectComposite() bool { return dec.start('{') }
+
+// StartArrayComposite decodes the start of a JSON array, i.e. '['
+// It returns false if a null was found, in which case there is no end to decode.
+func (dec Decoder) StartArrayComposite() bool { return dec.start('[') }
+
+// start array or object
+func (dec Decoder) start(delim json.Delim) bool {
+	tok := dec.mustToken()
+	if tok == nil {
+		return false
+	}
+	gotDelim, ok := tok.(json.Delim)
+	if !ok {
+		panic(dec.errorf("Expected %q but got %q", delim, tok))
+	}
+	if gotDelim != delim {
+		panic(dec.errorf("Expected %q but got %q", delim, gotDelim))
+	}
+	return true
+}
+
+// EndComposite will decode and discard the end of an array or object
+func (dec Decoder) EndComposite() {
+	// json.Decoder guarantees matching delimiters so no need to check
+	_ = dec.mustToken()
+}
+
+// DecodeString decodes a token and check that it is a string or null.
+// It returns nil if a null was found.
+func (dec Decoder) DecodeString() *string {
+	tok := dec.mustToken()
+	if tok == nil {
+		return nil
+	}
+	s, ok := tok.(string)
+	if !ok {
+		panic(dec.errorf("Expected string but got %q", tok))
+	}
+	return &s
+}
+
+// DecodeName decodes a token and checks that it is a non-null string
+func (dec Decoder) DecodeName() string {
+	if s := dec.DecodeString(); s != nil {
+		return *s
+	}
+	panic(dec.errorf("Expected JSON field name but got null"))
+}
+
+// DecodeNumber decodes a token and checks that it is a non-null number
+func (dec Decoder) DecodeNumber() json.Number {
+	tok := dec.mustToken()
+	n, ok := tok.(json.Number)
+	if !ok {
+		panic(dec.errorf("Expected number but got %q", tok))
+	}
+	return n
+}
+
+// DecodeNumberOrNull decodes a token and checks that it is a number or null.
+// It returns nil if a null was found.
+func (dec Decoder) DecodeNumberOrNull() *json.Number {
+	tok := dec.mustToken()
+	if tok == nil {
+		return nil
+	}
+	n, ok := tok.(json.Number)
+	if !ok {
+		panic(dec.errorf("Expected number but got %q", tok))
+	}
+	return &n
+}
+
+// DecodeBool decodes a token and checks that it is a boolean or null.
+// It returns nil if a null was found.
+func (dec Decoder) DecodeBool() *bool {
+	tok := dec.mustToken()
+	if tok == nil {
+		return nil
+	}
+	b, ok := tok.(bool)
+	if !ok {
+		panic(dec.errorf("Expected boolean but got %v", tok))
+	}
+	return &b
+}
+
+// DecodeValue decodes a token which is a number, null or a string, as the value of a
+// cell of a table, see value.Value.UnmarshalJSON. Numbers are Int if they are whole,
+// as counts are, and Float otherwise, as the values of weighted datasets may be.
+func (dec Decoder) DecodeValue() value.Value {
+	switch tok := dec.mustToken().(type) {
+	case nil:
+		return value.Value{}
+	case json.Number:
+		v, err := value.FromNumber(tok)
+		if err != nil {
+			panic(dec.locate(err))
+		}
+		return v
+	case string:
+		return value.NewFlagged(tok)
+	case bool:
+		panic(dec.errorf("Expected number, string or null but got %t", tok))
+	default:
+		panic(dec.errorf("Expected number, string or null but got %q", tok))
+	}
+}
+
+// DecodeRawMessage decodes the next value, which may be a composite, and returns
+// its JSON verbatim without converting it to Go values. This allows parts of a
+// response which are not understood to be forwarded unchanged.
+func (dec Decoder) DecodeRawMessage() json.RawMessage {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		panic(err)
+	}
+	return raw
+}
+
+// SkipValue decodes and discards the next value, which may be a composite. Unlike
+// DecodeRawMessage it decodes a composite a token at a time rather than holding it
+// in memory, so that fields which are not understood, such as those requested by
+// other queries or added to the API, may be skipped whatever their size.
+func (dec Decoder) SkipValue() {
+	depth := 0
+	for {
+		switch dec.mustToken() {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+// mustToken reads a token and panics on error
+func (dec Decoder) mustToken() json.Token {
+	tok, err := dec.Token()
+	if err != nil {
+		panic(err)
+	}
+	return tok
+}
+
+// Token returns the next token as json.Decoder.Token does, recording where it was
+// in the input. Errors are *Error.
+func (dec Decoder) Token() (json.Token, error) {
+	dec.pos.flush()
+	tok, err := dec.Decoder.Token()
+	if err != nil {
+		return nil, dec.locate(err)
+	}
+	dec.pos.token(tok)
+	return tok, nil
+}
+
+// Decode decodes the next value into v as json.Decoder.Decode does, recording where
+// it was in the input. Errors are *Error.
+func (dec Decoder) Decode(v interface{}) error {
+	dec.pos.flush()
+	if err := dec.Decoder.Decode(v); err != nil {
+		return dec.locate(err)
+	}
+	dec.pos.advance()
+	return nil
+}
+
+// locate returns err with the current position in the input.
+func (dec Decoder) locate(err error) *Error {
+	return &Error{Offset: dec.pos.padding.skipped + dec.InputOffset(), Path: dec.pos.path(), Err: err}
+}
+
+// errorf returns an error with the current position in the input.
+func (dec Decoder) errorf(format string, a ...interface{}) *Error {
+	return dec.locate(fmt.Errorf(format, a...))
+}
+
+// DecodeArrayFunc decodes a JSON array, calling fn for each element with the
+// decoder positioned at the start of the element. fn must decode the whole
+// element, e.g. with Decode or by decoding its tokens. A null is treated as an
+// empty array. If fn returns an error then decoding stops and it is returned.
+func DecodeArrayFunc(dec Decoder, fn func(dec Decoder) error) error {
+	if !dec.StartArrayComposite() {
+		return nil
+	}
+	for dec.More() {
+		if err := fn(dec); err != nil {
+			return err
+		}
+	}
+	dec.EndComposite()
+	return nil
+}
+
+// StreamArray decodes a JSON array one element at a time into values of type T,
+// calling fn with each. This allows arrays too large to hold in memory, such as
+// long category lists, to be processed. A null is treated as an empty array.
+// If fn returns an error then decoding stops and it is returned.
+func StreamArray[T any](dec Decoder, fn func(v T) error) error {
+	return DecodeArrayFunc(dec, func(dec Decoder) error {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			panic(err)
+		}
+		return fn(v)
+	})
+}
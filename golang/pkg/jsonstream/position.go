@@ -0,0 +1,109 @@
+package jsonstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Error is an error decoding the input, with where in it the error occurred, so that
+// a problem in a response of hundreds of megabytes can be found.
+type Error struct {
+	Offset int64  // offset in the input of the end of the last token decoded
+	Path   string // path of the value being decoded, e.g. data.dataset.table.values[10234]
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s at offset %d", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d in %s", e.Err, e.Offset, e.Path)
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// position tracks the path of the value being decoded from the tokens decoded. The
+// last token is only recorded once the next is decoded, so that an error in it, such
+// as an unexpected type, is reported at its path rather than that which follows it.
+type position struct {
+	padding *paddingSkipper // for the padding skipped, which json.Decoder doesn't see
+	frames  []frame         // the arrays and objects the decoder is within
+	last    json.Token      // the last token, if not yet recorded
+	pending bool
+}
+
+// frame is an array or object being decoded.
+type frame struct {
+	array   bool
+	index   int    // of an array, the number of elements decoded
+	name    string // of an object, the name of the current field
+	isValue bool   // of an object, whether the next token is the value of the field
+}
+
+// token records that tok was decoded, once the next token is.
+func (p *position) token(tok json.Token) {
+	p.flush()
+	p.last, p.pending = tok, true
+}
+
+// flush records the last token.
+func (p *position) flush() {
+	if !p.pending {
+		return
+	}
+	tok := p.last
+	p.last, p.pending = nil, false
+	var top *frame
+	if len(p.frames) > 0 {
+		top = &p.frames[len(p.frames)-1]
+	}
+	if top != nil && !top.array && !top.isValue {
+		if name, ok := tok.(string); ok {
+			top.name, top.isValue = name, true
+			return
+		}
+	}
+	switch tok {
+	case json.Delim('{'), json.Delim('['):
+		p.frames = append(p.frames, frame{array: tok == json.Delim('[')})
+	case json.Delim('}'), json.Delim(']'):
+		p.frames = p.frames[:len(p.frames)-1]
+		p.advance()
+	default:
+		p.advance()
+	}
+}
+
+// advance records that a whole value was decoded, advancing to the next element of
+// the array or field of the object containing it.
+func (p *position) advance() {
+	if len(p.frames) == 0 {
+		return
+	}
+	top := &p.frames[len(p.frames)-1]
+	if top.array {
+		top.index++
+	} else {
+		top.name, top.isValue = "", false
+	}
+}
+
+// path returns the path of the value being decoded, of the names of the fields of
+// objects separated by dots and the indices of the elements of arrays in brackets.
+func (p *position) path() string {
+	var b strings.Builder
+	for _, f := range p.frames {
+		switch {
+		case f.array:
+			b.WriteString("[" + strconv.Itoa(f.index) + "]")
+		case f.name != "":
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(f.name)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package table
+
+import "github.com/cantabular/examples/pkg/value"
+
+// Margins accumulates the margins of a table over one of its dimensions: for each
+// cell, the total of the values of the cells which differ from it only in their
+// category of that dimension. Taken in row-major order, the cells of a table form
+// blocks within which that dimension and all later ones take every category, and
+// the margins of the cells of a block depend only on the cells of that block. So a
+// table may be processed a block at a time, and its margins over the last dimension
+// found by holding just one row of it.
+type Margins struct {
+	block, inner int
+	totals       []float64
+}
+
+// NewMargins returns the margins over the dimension at index over, or if over is
+// negative the grand total of the table.
+func NewMargins(dims Dimensions, over int) *Margins {
+	m := &Margins{block: 1, inner: 1}
+	for d := len(dims) - 1; d >= 0 && d >= over; d-- {
+		if d > over && over >= 0 {
+			m.inner *= dims[d].Count
+		}
+		m.block *= dims[d].Count
+	}
+	m.totals = make([]float64, m.inner)
+	return m
+}
+
+// BlockSize returns the number of cells in each block.
+func (m *Margins) BlockSize() int {
+	return m.block
+}
+
+// Reset clears the totals for the next block.
+func (m *Margins) Reset() {
+	for i := range m.totals {
+		m.totals[i] = 0
+	}
+}
+
+// Add adds the value of the i-th cell of the block to its margin. Values which are
+// not numbers are left out, as for Totals.
+func (m *Margins) Add(i int, v value.Value) {
+	f, _ := v.Number()
+	m.totals[i%m.inner] += f
+}
+
+// Count returns the number of margins of each block.
+func (m *Margins) Count() int {
+	return m.inner
+}
+
+// Index returns the index, less than Count, of the margin of the i-th cell of the
+// block, so that the cells of a block may be grouped by their margin.
+func (m *Margins) Index(i int) int {
+	return i % m.inner
+}
+
+// Total returns the margin of the i-th cell of the block, once every cell of the block has been added.
+func (m *Margins) Total(i int) float64 {
+	return m.totals[i%m.inner]
+}
@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// Association accumulates a two-way table to measure the association between its variables.
+// Unlike Summary the observed cell values are held in memory.
+type Association struct {
+	dims     table.Dimensions
+	ti       *table.Iterator
+	observed [][]float64
+}
+
+// NewAssociation creates an Association of a table with these Dimensions,
+// which must be those of a two-way table.
+func NewAssociation(dims table.Dimensions) (*Association, error) {
+	if len(dims) != 2 {
+		return nil, fmt.Errorf("association statistics need a table of 2 variables but there are %d", len(dims))
+	}
+	observed := make([][]float64, dims[0].Count)
+	for i := range observed {
+		observed[i] = make([]float64, dims[1].Count)
+	}
+	return &Association{dims: dims, ti: dims.NewIterator(), observed: observed}, nil
+}
+
+// Add accumulates the value of the next cell in row-major order.
+func (a *Association) Add(value float64) {
+	a.observed[a.ti.CategoryIndexAtColumn(0)][a.ti.CategoryIndexAtColumn(1)] = value
+	a.ti.Next()
+}
+
+// margins returns the row totals, column totals and the grand total.
+func (a *Association) margins() (rows, cols []float64, total float64) {
+	rows, cols = make([]float64, a.dims[0].Count), make([]float64, a.dims[1].Count)
+	for i, row := range a.observed {
+		for j, v := range row {
+			rows[i] += v
+			cols[j] += v
+			total += v
+		}
+	}
+	return rows, cols, total
+}
+
+// Expected returns the expected cell values if the variables were independent.
+func (a *Association) Expected() [][]float64 {
+	rows, cols, total := a.margins()
+	expected := make([][]float64, len(rows))
+	for i := range rows {
+		expected[i] = make([]float64, len(cols))
+		for j := range cols {
+			if total > 0 {
+				expected[i][j] = rows[i] * cols[j] / total
+			}
+		}
+	}
+	return expected
+}
+
+// ChiSquare returns Pearson's chi-square statistic and its degrees of freedom.
+// Categories with a zero total are excluded as they contribute no information.
+func (a *Association) ChiSquare() (chi2 float64, df int) {
+	expected := a.Expected()
+	for i, row := range a.observed {
+		for j, v := range row {
+			if e := expected[i][j]; e > 0 {
+				chi2 += (v - e) * (v - e) / e
+			}
+		}
+	}
+	r, c := a.nonEmpty()
+	if r > 0 && c > 0 {
+		df = (r - 1) * (c - 1)
+	}
+	return chi2, df
+}
+
+// CramersV returns Cramér's V, which is between zero for no association
+// and one for complete association. It is NaN if V is undefined.
+func (a *Association) CramersV() float64 {
+	chi2, _ := a.ChiSquare()
+	_, _, total := a.margins()
+	r, c := a.nonEmpty()
+	k := r
+	if c < k {
+		k = c
+	}
+	if k < 2 || total == 0 {
+		return math.NaN()
+	}
+	return math.Sqrt(chi2 / (total * float64(k-1)))
+}
+
+// nonEmpty returns the number of rows and columns with a non-zero total.
+func (a *Association) nonEmpty() (r, c int) {
+	rows, cols, _ := a.margins()
+	for _, v := range rows {
+		if v != 0 {
+			r++
+		}
+	}
+	for _, v := range cols {
+		if v != 0 {
+			c++
+		}
+	}
+	return r, c
+}
+
+// WriteTo writes the statistics followed by the observed and expected
+// value of each cell in a human readable layout to w.
+func (a *Association) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	chi2, df := a.ChiSquare()
+	_, _ = fmt.Fprintf(tw, "Chi-square\t%g\n", chi2)
+	_, _ = fmt.Fprintf(tw, "Degrees of freedom\t%d\n", df)
+	_, _ = fmt.Fprintf(tw, "Cramér's V\t%g\n", a.CramersV())
+	_, _ = fmt.Fprintln(tw)
+	_, _ = fmt.Fprintf(tw, "%s\t%s\tobserved\texpected\n", a.dims[0].Variable.Label, a.dims[1].Variable.Label)
+	expected := a.Expected()
+	for i, row := range a.observed {
+		for j, v := range row {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%g\t%.2f\n",
+				a.dims[0].Categories[i].Label, a.dims[1].Categories[j].Label, v, expected[i][j])
+		}
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
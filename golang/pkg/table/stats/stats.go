@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+
+	"github.com/cantabular/examples/pkg/table"
+)
+
+// Summary accumulates summary statistics of the cells of a table in a single pass,
+// so that the cell values never need to be held in memory.
+type Summary struct {
+	dims            table.Dimensions
+	ti              *table.Iterator
+	Cells, NonZero  int
+	Total, Min, Max float64
+	// Margins holds the marginal totals for each category of each dimension
+	Margins [][]float64
+}
+
+// NewSummary creates a Summary of a table with these Dimensions.
+func NewSummary(dims table.Dimensions) *Summary {
+	margins := make([][]float64, len(dims))
+	for i, d := range dims {
+		margins[i] = make([]float64, d.Count)
+	}
+	return &Summary{
+		dims:    dims,
+		ti:      dims.NewIterator(),
+		Min:     math.Inf(1),
+		Max:     math.Inf(-1),
+		Margins: margins,
+	}
+}
+
+// Add accumulates the value of the next cell in row-major order.
+func (s *Summary) Add(value float64) {
+	for i := range s.dims {
+		s.Margins[i][s.ti.CategoryIndexAtColumn(i)] += value
+	}
+	s.ti.Next()
+	s.Cells++
+	if value != 0 {
+		s.NonZero++
+	}
+	s.Total += value
+	s.Min = math.Min(s.Min, value)
+	s.Max = math.Max(s.Max, value)
+}
+
+// Mean returns the mean cell value, or NaN if there are no cells.
+func (s *Summary) Mean() float64 {
+	return s.Total / float64(s.Cells)
+}
+
+// WriteTo writes the summary in a human readable layout to w.
+func (s *Summary) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	tw := tabwriter.NewWriter(cw, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Cells\t%d\n", s.Cells)
+	_, _ = fmt.Fprintf(tw, "Non-zero cells\t%d\n", s.NonZero)
+	_, _ = fmt.Fprintf(tw, "Total\t%g\n", s.Total)
+	if s.Cells > 0 {
+		_, _ = fmt.Fprintf(tw, "Min\t%g\n", s.Min)
+		_, _ = fmt.Fprintf(tw, "Max\t%g\n", s.Max)
+		_, _ = fmt.Fprintf(tw, "Mean\t%g\n", s.Mean())
+	}
+	for i, d := range s.dims {
+		_, _ = fmt.Fprintf(tw, "\nTotals by %s\n", d.Variable.Label)
+		for j, c := range d.Categories {
+			_, _ = fmt.Fprintf(tw, "  %s\t%g\n", c.Label, s.Margins[i][j])
+		}
+	}
+	err := tw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
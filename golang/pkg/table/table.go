@@ -0,0 +1,172 @@
+package table
+
+import (
+	"sort"
+
+	"github.com/cantabular/examples/pkg/value"
+)
+
+type (
+	// Dimensions describes the structure of a table
+	Dimensions []struct {
+		Count      int        `json:"count"`
+		Categories []Category `json:"categories"`
+		Variable   struct {
+			Name  string `json:"name"`
+			Label string `json:"label"`
+		} `json:"variable"`
+	}
+
+	// Category represents one of the possible values of a variable
+	Category struct {
+		Code  string `json:"code"`
+		Label string `json:"label"`
+	}
+
+	// Iterator facilitates reading the coordinates of each cell in row-major order
+	Iterator struct {
+		dims       Dimensions
+		dimIndices []int
+		empty      bool
+	}
+)
+
+// NewIterator creates an iterator over a table on these Dimensions. If any dimension
+// is empty then the table has no cells, so the iterator is already at the end.
+func (dims Dimensions) NewIterator() *Iterator {
+	return &Iterator{
+		dims:       dims,
+		dimIndices: make([]int, len(dims)),
+		empty:      len(dims.Empty()) > 0,
+	}
+}
+
+// Empty returns the variable names of the dimensions with no categories, as when a
+// filter removes every category of a variable. If there are any the table has no cells.
+func (dims Dimensions) Empty() []string {
+	var names []string
+	for _, d := range dims {
+		if d.Count == 0 {
+			names = append(names, d.Variable.Name)
+		}
+	}
+	return names
+}
+
+// Sorted returns a copy of the dimensions with the categories of each sorted by less,
+// and for each cell of the sorted table in row-major order, the index of the same cell
+// in the original table.
+func (dims Dimensions) Sorted(less func(a, b Category) bool) (Dimensions, []int) {
+	sorted := make(Dimensions, len(dims))
+	copy(sorted, dims)
+	orders := make([][]int, len(dims)) // original index of each sorted category
+	strides := make([]int, len(dims))
+	cells := 1
+	for d := len(dims) - 1; d >= 0; d-- {
+		order := make([]int, len(dims[d].Categories))
+		for i := range order {
+			order[i] = i
+		}
+		cats := dims[d].Categories
+		sort.SliceStable(order, func(i, j int) bool { return less(cats[order[i]], cats[order[j]]) })
+		sorted[d].Categories = make([]Category, len(order))
+		for i, o := range order {
+			sorted[d].Categories[i] = cats[o]
+		}
+		orders[d], strides[d] = order, cells
+		cells *= dims[d].Count
+	}
+	index := make([]int, 0, cells)
+	if cells == 0 {
+		return sorted, index
+	}
+	for ti := sorted.NewIterator(); !ti.End(); ti.Next() {
+		i := 0
+		for d := range sorted {
+			i += orders[d][ti.CategoryIndexAtColumn(d)] * strides[d]
+		}
+		index = append(index, i)
+	}
+	return sorted, index
+}
+
+// End returns true if there are no more cells in the table
+func (ti *Iterator) End() bool {
+	return ti.empty || ti.dimIndices[0] >= ti.dims[0].Count
+}
+
+// Next advances to the next table cell. It should not be called if End() would return true.
+func (ti *Iterator) Next() {
+	ti.checkNotAtEnd()
+	for j := len(ti.dimIndices) - 1; j >= 0; j -= 1 {
+		if ti.dimIndices[j] += 1; ti.dimIndices[j] < ti.dims[j].Count || j == 0 {
+			break
+		}
+		ti.dimIndices[j] = 0
+	}
+}
+
+// CategoryAtColumn returns the i-th coordinate of the current cell
+func (ti *Iterator) CategoryAtColumn(i int) Category {
+	ti.checkNotAtEnd()
+	return ti.dims[i].Categories[ti.dimIndices[i]]
+}
+
+// CategoryIndexAtColumn returns the index of the i-th coordinate of the current cell
+// within the categories of the i-th dimension
+func (ti *Iterator) CategoryIndexAtColumn(i int) int {
+	ti.checkNotAtEnd()
+	return ti.dimIndices[i]
+}
+
+// AppendCategories appends the coordinates of the current cell to dst, each formatted
+// by label and, if codes is true, followed by its category code.
+func (ti *Iterator) AppendCategories(dst []value.Value, label func(Category) string, codes bool) []value.Value {
+	return ti.AppendLeading(dst, label, codes, len(ti.dims))
+}
+
+// AppendLeading appends the coordinates of the current cell for the first d dimensions
+// to dst, as for AppendCategories, such as those of a row of a pivoted table.
+func (ti *Iterator) AppendLeading(dst []value.Value, label func(Category) string, codes bool, d int) []value.Value {
+	for i := 0; i < d; i++ {
+		c := ti.CategoryAtColumn(i)
+		dst = append(dst, value.NewString(label(c)))
+		if codes {
+			dst = append(dst, value.NewString(c.Code))
+		}
+	}
+	return dst
+}
+
+// LastInCategory returns true if the current cell is the last in row-major order of
+// those with its category of the d-th dimension, when every later dimension is at
+// its last category.
+func (ti *Iterator) LastInCategory(d int) bool {
+	ti.checkNotAtEnd()
+	for i := d + 1; i < len(ti.dims); i++ {
+		if ti.dimIndices[i] < ti.dims[i].Count-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// AppendMargin appends the coordinates of a margin of the table to dst: those of the
+// current cell for the first d dimensions, as for AppendCategories, and total for the
+// rest, which are summed over, with an empty code.
+func (ti *Iterator) AppendMargin(dst []value.Value, label func(Category) string, codes bool, d int, total string) []value.Value {
+	dst = ti.AppendLeading(dst, label, codes, d)
+	for i := d; i < len(ti.dims); i++ {
+		dst = append(dst, value.NewString(total))
+		if codes {
+			dst = append(dst, value.NewString(""))
+		}
+	}
+	return dst
+}
+
+func (ti *Iterator) checkNotAtEnd() {
+	if ti.End() {
+		panic("after end of table")
+	}
+}
@@ -0,0 +1,30 @@
+package table
+
+import "github.com/cantabular/examples/pkg/value"
+
+// Totals accumulates sums of the values of a table as its cells are iterated in
+// row-major order: the subtotal of each category of a dimension, which is complete
+// once Iterator.LastInCategory is true for the dimension, and the grand total.
+type Totals struct {
+	subtotal, total float64
+}
+
+// Add adds the value of the current cell. Values which are not numbers, such as
+// those of suppressed cells, are left out of the totals.
+func (t *Totals) Add(v value.Value) {
+	f, _ := v.Number()
+	t.subtotal += f
+	t.total += f
+}
+
+// Subtotal returns the sum of the values added since Subtotal was last called.
+func (t *Totals) Subtotal() float64 {
+	s := t.subtotal
+	t.subtotal = 0
+	return s
+}
+
+// Total returns the sum of every value added.
+func (t *Totals) Total() float64 {
+	return t.total
+}
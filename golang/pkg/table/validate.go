@@ -0,0 +1,38 @@
+package table
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DuplicateCodesError reports categories of a dimension which have the same code, so
+// that anything matching categories by code, such as filters, the sub-queries of a
+// split query or joins on a -codes column, would confuse them.
+type DuplicateCodesError struct {
+	Variable string
+	Codes    []string // each duplicated code once, in order of first duplication
+}
+
+func (e *DuplicateCodesError) Error() string {
+	return fmt.Sprintf("variable %s has duplicate category codes: %s", e.Variable, strings.Join(e.Codes, ", "))
+}
+
+// Validate checks that the codes of the categories of each dimension are unique.
+// The error joins a *DuplicateCodesError for each dimension where they are not.
+func (dims Dimensions) Validate() error {
+	var errs []error
+	for _, d := range dims {
+		seen := make(map[string]int, len(d.Categories))
+		var dups []string
+		for _, c := range d.Categories {
+			if seen[c.Code]++; seen[c.Code] == 2 {
+				dups = append(dups, c.Code)
+			}
+		}
+		if len(dups) > 0 {
+			errs = append(errs, &DuplicateCodesError{Variable: d.Variable.Name, Codes: dups})
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,159 @@
+// Package value defines the typed values of the cells of a table and of the rows
+// written to sinks, so that measures other than whole counts, such as weighted or
+// missing values, are carried from decoding to every output without each having to
+// parse or format them.
+package value
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Kind is the type of a Value.
+type Kind uint8
+
+const (
+	Null    Kind = iota // no value, as for a cell the API does not give one
+	Int                 // a whole number, such as a count
+	Float               // a number which may be fractional, such as a weighted count
+	String              // text, such as a category label
+	Flagged             // a marker in place of a number, such as for a suppressed cell
+)
+
+var kindNames = [...]string{Null: "null", Int: "int", Float: "float", String: "string", Flagged: "flagged"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Value is a typed value. The zero Value is Null.
+type Value struct {
+	kind     Kind
+	i        int64
+	f        float64
+	s        string // of a String, or the flag of a Flagged
+	decimals int    // places a Float is formatted with, or -1 for as many as needed
+}
+
+// NewInt returns an Int.
+func NewInt(n int64) Value { return Value{kind: Int, i: n} }
+
+// NewFloat returns a Float formatted with decimals places, or if decimals is negative
+// with as few digits as represent it exactly, so that whole numbers have no decimal point.
+// The number is rounded to the places too, so that typed outputs hold it as written to CSV.
+func NewFloat(f float64, decimals int) Value {
+	if decimals >= 0 && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'f', decimals, 64), 64)
+	}
+	return Value{kind: Float, f: f, decimals: decimals}
+}
+
+// NewString returns a String.
+func NewString(s string) Value { return Value{kind: String, s: s} }
+
+// NewFlagged returns a Flagged value with the flag, e.g. "x" for a suppressed cell.
+func NewFlagged(flag string) Value { return Value{kind: Flagged, s: flag} }
+
+// FromNumber returns an Int if n is a whole number in the range of int64, and
+// otherwise a Float formatted with as many places as needed.
+func FromNumber(n json.Number) (Value, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return NewInt(i), nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		return Value{}, err
+	}
+	return NewFloat(f, -1), nil
+}
+
+// Kind returns the type of v.
+func (v Value) Kind() Kind { return v.kind }
+
+// Number returns the value of an Int or Float as a float64, and false for other kinds.
+func (v Value) Number() (float64, bool) {
+	switch v.kind {
+	case Int:
+		return float64(v.i), true
+	case Float:
+		return v.f, true
+	}
+	return 0, false
+}
+
+// Int64 returns the value of an Int, or of a Float which is a whole number.
+func (v Value) Int64() (int64, error) {
+	switch v.kind {
+	case Int:
+		return v.i, nil
+	case Float:
+		if v.f == math.Trunc(v.f) && math.Abs(v.f) <= math.MaxInt64 {
+			return int64(v.f), nil
+		}
+	}
+	return 0, fmt.Errorf("%s %s is not a whole number", v.kind, v)
+}
+
+// Float64 returns the value of an Int or Float.
+func (v Value) Float64() (float64, error) {
+	if f, ok := v.Number(); ok {
+		return f, nil
+	}
+	return 0, fmt.Errorf("%s %q is not a number", v.kind, v)
+}
+
+// String returns v as text, as written to CSV: "" for Null and the flag of a Flagged.
+func (v Value) String() string {
+	switch v.kind {
+	case Int:
+		return strconv.FormatInt(v.i, 10)
+	case Float:
+		return strconv.FormatFloat(v.f, 'f', v.decimals, 64)
+	case String, Flagged:
+		return v.s
+	}
+	return ""
+}
+
+// MarshalJSON encodes numbers as JSON numbers, Null as null and other kinds as strings.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.kind {
+	case Null:
+		return []byte("null"), nil
+	case Int:
+		return strconv.AppendInt(nil, v.i, 10), nil
+	case Float:
+		if math.IsInf(v.f, 0) || math.IsNaN(v.f) {
+			return nil, fmt.Errorf("%v cannot be encoded as JSON", v.f)
+		}
+		return strconv.AppendFloat(nil, v.f, 'g', -1, 64), nil
+	}
+	return json.Marshal(v.s)
+}
+
+// UnmarshalJSON decodes a value of a table as the API gives it: a number as by
+// FromNumber, null as Null, or a string, which is a flag in place of a number.
+func (v *Value) UnmarshalJSON(b []byte) error {
+	switch {
+	case string(b) == "null":
+		*v = Value{}
+	case len(b) > 0 && b[0] == '"':
+		var flag string
+		if err := json.Unmarshal(b, &flag); err != nil {
+			return err
+		}
+		*v = NewFlagged(flag)
+	default:
+		n, err := FromNumber(json.Number(b))
+		if err != nil {
+			return fmt.Errorf("value %s is not a number, string or null", b)
+		}
+		*v = n
+	}
+	return nil
+}